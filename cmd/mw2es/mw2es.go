@@ -99,6 +99,9 @@ func Execute() error {
 	rootCmd.PersistentFlags().StringVarP(&config, "config", "c", "config.toml", "config file")
 	rootCmd.AddCommand(newLdbCommand())
 	rootCmd.AddCommand(newESCommand())
+	rootCmd.AddCommand(newOffsetCommand())
+	rootCmd.AddCommand(newTokenCommand())
+	rootCmd.AddCommand(newScriptCommand())
 	return rootCmd.Execute()
 }
 
@@ -116,9 +119,198 @@ func newLdbCommand() *cobra.Command {
 			return nil
 		},
 	}
+	cmd.AddCommand(newLdbServeCommand())
+	cmd.AddCommand(newLdbAsOfCommand())
+	cmd.AddCommand(newLdbFlashbackCommand())
+	cmd.AddCommand(newLdbSQLExportCommand())
+	cmd.AddCommand(newLdbExportCommand())
+	cmd.AddCommand(newLdbBackupCommand())
+	cmd.AddCommand(newLdbRestoreCommand())
 	return cmd
 }
 
+func newLdbServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "open the leveldb read-only and serve http queries without consuming kafka",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := struct {
+				Config string
+				Logger *zap.Logger
+			}{Logger: logger, Config: config}
+			return mw2ldb.NewServer(opts).Serve()
+		},
+	}
+	return cmd
+}
+
+func newLdbAsOfCommand() *cobra.Command {
+	var opt mw2ldb.RowOpt
+	cmd := &cobra.Command{
+		Use:   "asof",
+		Short: "restore a row or a whole table as of a timestamp, output ndjson",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := struct {
+				Config string
+				Logger *zap.Logger
+			}{Logger: logger, Config: config}
+			return mw2ldb.NewServer(opts).AsOf(opt, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.Database, "db", "", "database")
+	cmd.Flags().StringVar(&opt.Table, "tb", "", "table")
+	cmd.Flags().StringVar(&opt.PkID, "pk", "", "primary key, the whole table if empty")
+	cmd.Flags().StringVar(&opt.At, "at", "", "timestamp: 20060102150405, RFC3339, unix seconds/milliseconds or relative like -1h")
+	cmd.Flags().StringVar(&opt.TZ, "tz", "", "timezone of 20060102150405 timestamps, e.g. UTC, Asia/Shanghai or +08:00, local by default")
+	_ = cmd.MarkFlagRequired("db")
+	_ = cmd.MarkFlagRequired("tb")
+	_ = cmd.MarkFlagRequired("at")
+	return cmd
+}
+
+func newLdbFlashbackCommand() *cobra.Command {
+	opt := mw2ldb.FlashbackOpt{Reveal: true} // 本地命令读取配置文件中的密钥, 解密 encrypt 的列
+	cmd := &cobra.Command{
+		Use:   "flashback",
+		Short: "generate rollback sql from the change log",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := struct {
+				Config string
+				Logger *zap.Logger
+			}{Logger: logger, Config: config}
+			return mw2ldb.NewServer(opts).Flashback(opt, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.Database, "db", "", "database")
+	cmd.Flags().StringVar(&opt.Table, "tb", "", "table")
+	cmd.Flags().StringVar(&opt.BeginTime, "start", "", "start time: 20060102150405, RFC3339, unix seconds/milliseconds or relative like -1h")
+	cmd.Flags().StringVar(&opt.EndTime, "end", "", "end time, same formats as --start")
+	cmd.Flags().StringVar(&opt.TZ, "tz", "", "timezone of 20060102150405 timestamps, local by default")
+	cmd.Flags().StringVar(&opt.PkID, "pk", "", "primary key, optional")
+	cmd.Flags().BoolVar(&opt.AllowLossy, "allow-lossy", false, "generate sql for tables with drop/hash/mask redact rules, transforms or scripts, these columns can not be restored")
+	_ = cmd.MarkFlagRequired("db")
+	_ = cmd.MarkFlagRequired("tb")
+	return cmd
+}
+
+func newLdbSQLExportCommand() *cobra.Command {
+	opt := mw2ldb.SQLExportOpt{Reveal: true}
+	cmd := &cobra.Command{
+		Use:   "sqlexport",
+		Short: "export the change log as sql statements grouped by transaction",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := struct {
+				Config string
+				Logger *zap.Logger
+			}{Logger: logger, Config: config}
+			return mw2ldb.NewServer(opts).SQLExport(opt, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.Database, "db", "", "database")
+	cmd.Flags().StringVar(&opt.Table, "tb", "", "table, all tables of the database if empty")
+	cmd.Flags().StringVar(&opt.BeginTime, "start", "", "start time: 20060102150405, RFC3339, unix seconds/milliseconds or relative like -1h")
+	cmd.Flags().StringVar(&opt.EndTime, "end", "", "end time, same formats as --start")
+	cmd.Flags().StringVar(&opt.TZ, "tz", "", "timezone of 20060102150405 timestamps, local by default")
+	cmd.Flags().StringVar(&opt.Dialect, "dialect", "mysql", "mysql or sqlite")
+	cmd.Flags().BoolVar(&opt.Upsert, "upsert", false, "write inserts and updates as upserts")
+	cmd.Flags().BoolVar(&opt.AllowLossy, "allow-lossy", false, "generate sql for tables with drop/hash/mask redact rules, transforms or scripts, these columns can not be restored")
+	_ = cmd.MarkFlagRequired("db")
+	return cmd
+}
+
+func newLdbExportCommand() *cobra.Command {
+	var opt mw2ldb.ExportOpt
+	var source string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "export the rows or the change log of a table as ndjson or csv",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := struct {
+				Config string
+				Logger *zap.Logger
+			}{Logger: logger, Config: config}
+			opt.Log = source == "log"
+			return mw2ldb.NewServer(opts).Export(opt, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.Database, "db", "", "database")
+	cmd.Flags().StringVar(&opt.Table, "tb", "", "table")
+	cmd.Flags().StringVar(&opt.Format, "format", "ndjson", "ndjson or csv")
+	cmd.Flags().StringVar(&source, "source", "row", "row or log")
+	cmd.Flags().StringVar(&opt.BeginTime, "start", "", "start time of the log: 20060102150405, RFC3339, unix seconds/milliseconds or relative like -1h")
+	cmd.Flags().StringVar(&opt.EndTime, "end", "", "end time of the log, same formats as --start")
+	cmd.Flags().StringVar(&opt.TZ, "tz", "", "timezone of 20060102150405 timestamps, local by default")
+	_ = cmd.MarkFlagRequired("db")
+	_ = cmd.MarkFlagRequired("tb")
+	return cmd
+}
+
+func newLdbBackupCommand() *cobra.Command {
+	var url, tokenFile, out string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "take a consistent snapshot of the leveldb",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := struct {
+				Config string
+				Logger *zap.Logger
+			}{Logger: logger, Config: config}
+
+			token, err := bearerToken(tokenFile)
+			if err != nil {
+				return err
+			}
+
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+
+			if err := mw2ldb.NewServer(opts).Backup(url, token, f); err != nil {
+				_ = f.Close()
+				_ = os.Remove(out)
+				return err
+			}
+			return f.Close()
+		},
+	}
+
+	cmd.Flags().StringVar(&url, "url", "", "download from a running server, e.g. http://127.0.0.1:8081")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "file of the bearer token, "+tokenEnv+" if empty")
+	cmd.Flags().StringVarP(&out, "out", "o", "", "backup file")
+	_ = cmd.MarkFlagRequired("out")
+	return cmd
+}
+
+func newLdbRestoreCommand() *cobra.Command {
+	var in string
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "rebuild the leveldb from a backup file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := struct {
+				Config string
+				Logger *zap.Logger
+			}{Logger: logger, Config: config}
+
+			f, err := os.Open(in)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+
+			return mw2ldb.NewServer(opts).Restore(f)
+		},
+	}
+
+	cmd.Flags().StringVarP(&in, "in", "i", "", "backup file")
+	_ = cmd.MarkFlagRequired("in")
+	return cmd
+}
 
 func newESCommand() *cobra.Command {
 	cmd := &cobra.Command{
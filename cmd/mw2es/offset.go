@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// offset 命令通过管理接口查看和重置运行中服务的 kafka offset, ldb 和 es 通用
+func newOffsetCommand() *cobra.Command {
+	var addr, tokenFile string
+	cmd := &cobra.Command{
+		Use:   "offset",
+		Short: "get or set the kafka offset of a running server",
+	}
+	cmd.PersistentFlags().StringVar(&addr, "addr", "http://127.0.0.1:8081", "admin address of the server")
+	cmd.PersistentFlags().StringVar(&tokenFile, "token-file", "", "file of the bearer token, "+tokenEnv+" if empty")
+
+	get := &cobra.Command{
+		Use:   "get",
+		Short: "show the stored offset and the oldest/newest offsets of the partition",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return adminCall(http.MethodGet, addr, tokenFile, "/admin/offset", nil)
+		},
+	}
+
+	var (
+		partition      int32
+		offset         int64
+		t              string
+		oldest, newest bool
+		discard        bool
+	)
+	set := &cobra.Command{
+		Use:   "set",
+		Short: "stop consuming, store a new offset and restart consuming from it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := url.Values{}
+			q.Set("partition", strconv.Itoa(int(partition)))
+			switch {
+			case cmd.Flags().Changed("offset"):
+				q.Set("offset", strconv.FormatInt(offset, 10))
+			case len(t) > 0:
+				q.Set("time", t)
+			case oldest:
+				q.Set("oldest", "1")
+			case newest:
+				q.Set("newest", "1")
+			default:
+				return fmt.Errorf("one of --offset/--time/--oldest/--newest is required")
+			}
+
+			if discard {
+				q.Set("discard", "1")
+			}
+			return adminCall(http.MethodPost, addr, tokenFile, "/admin/offset", q)
+		},
+	}
+
+	set.Flags().Int32Var(&partition, "partition", 0, "partition")
+	set.Flags().Int64Var(&offset, "offset", 0, "next offset to consume")
+	set.Flags().StringVar(&t, "time", "", "first offset at or after the time, RFC3339")
+	set.Flags().BoolVar(&oldest, "oldest", false, "the oldest offset")
+	set.Flags().BoolVar(&newest, "newest", false, "the newest offset")
+	set.Flags().BoolVar(&discard, "discard", false, "discard the cache instead of flushing it")
+
+	cmd.AddCommand(get, set)
+	return cmd
+}
+
+func adminCall(method, addr, tokenFile, path string, q url.Values) error {
+	token, err := bearerToken(tokenFile)
+	if err != nil {
+		return err
+	}
+
+	u := strings.TrimSuffix(addr, "/") + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		bs, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(bs)))
+	}
+
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/helloshiki/maxwell-output/pkg/script"
+	"github.com/spf13/cobra"
+)
+
+// script 命令管理 [TableConfigs.xxx.Script] 的脚本
+func newScriptCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "script",
+		Short: "event hook scripts",
+	}
+	cmd.AddCommand(newScriptTestCommand())
+	return cmd
+}
+
+func newScriptTestCommand() *cobra.Command {
+	var (
+		c      script.Config
+		events string
+	)
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "run a script against sample maxwell events, read as ndjson or a json array",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.Validate(); err != nil {
+				return err
+			}
+			s := c.Script()
+
+			var r io.Reader = os.Stdin
+			if len(events) > 0 {
+				f, err := os.Open(events)
+				if err != nil {
+					return err
+				}
+				defer func() { _ = f.Close() }()
+				r = f
+			}
+
+			return s.Test(r, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&c.File, "file", "f", "", "lua script")
+	cmd.Flags().StringVarP(&events, "events", "e", "", "sample events, stdin if empty")
+	cmd.Flags().DurationVar(&c.Timeout, "timeout", script.DefaultTimeout, "time limit of each event")
+	cmd.Flags().IntVar(&c.MaxString, "max-string", script.DefaultMaxString, "size limit of string.rep results in MB")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
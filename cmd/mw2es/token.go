@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/helloshiki/maxwell-output/pkg/auth"
+	"github.com/helloshiki/maxwell-output/pkg/configparser"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// secretEnv 和服务端一样, 可以用环境变量覆盖配置文件中的 [Misc.Auth] HMACSecret
+const secretEnv = "AD_MISC_AUTH_HMACSECRET"
+
+// tokenEnv 是调用管理接口的 bearer token, 没有 --token-file 时使用
+const tokenEnv = "AD_TOKEN"
+
+// bearerToken 从 --token-file 或者环境变量读取 token, 不通过命令行参数传入, 避免出现在 ps 和 shell 历史中
+func bearerToken(file string) (string, error) {
+	if len(file) == 0 {
+		return os.Getenv(tokenEnv), nil
+	}
+
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+// hmacSecret 从环境变量或者 --config 指定的配置文件读取 HMACSecret, 不通过命令行参数传入
+func hmacSecret() (string, error) {
+	if s := os.Getenv(secretEnv); len(s) > 0 {
+		return s, nil
+	}
+
+	var c struct {
+		Misc struct {
+			Auth auth.Config `mapstructure:"Auth"`
+		} `mapstructure:"Misc"`
+	}
+	if err := configparser.LoadConfig(&c, "AD", config); err != nil {
+		return "", err
+	}
+
+	if len(c.Misc.Auth.HMACSecret) == 0 {
+		return "", errors.Errorf("HMACSecret is not set in [Misc.Auth] of %s or %s", config, secretEnv)
+	}
+	return c.Misc.Auth.HMACSecret, nil
+}
+
+// token 命令用 [Misc.Auth] 中的 HMACSecret 签发 token
+func newTokenCommand() *cobra.Command {
+	var (
+		client auth.Client
+		ttl    time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "sign a token with the hmac secret of the http api, read from the config file or " + secretEnv,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			secret, err := hmacSecret()
+			if err != nil {
+				return err
+			}
+
+			var exp time.Time
+			if ttl > 0 {
+				exp = time.Now().Add(ttl)
+			}
+			fmt.Println(auth.Sign(secret, &client, exp))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&client.Name, "name", "", "name of the client")
+	cmd.Flags().StringSliceVar(&client.Scopes, "scope", nil, "allowed db.table, db.* or *, repeatable")
+	cmd.Flags().BoolVar(&client.Admin, "admin", false, "allow the admin endpoints")
+	cmd.Flags().BoolVar(&client.Decrypt, "decrypt", false, "decrypt the encrypted columns in query responses")
+	cmd.Flags().DurationVar(&ttl, "ttl", 24*time.Hour, "lifetime of the token, 0 for never expire")
+	return cmd
+}
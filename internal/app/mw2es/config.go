@@ -6,13 +6,23 @@ import (
 	"strings"
 	"time"
 
+	"github.com/helloshiki/maxwell-output/pkg/auth"
 	"github.com/helloshiki/maxwell-output/pkg/configparser"
+	"github.com/helloshiki/maxwell-output/pkg/normalize"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/helloshiki/maxwell-output/pkg/redact"
+	"github.com/helloshiki/maxwell-output/pkg/script"
+	"github.com/helloshiki/maxwell-output/pkg/tombstone"
+	"github.com/helloshiki/maxwell-output/pkg/transform"
 )
 
 type config struct {
 	DBConfigs    map[string]*DBConfig    `mapstructure:"DBConfigs"`
 	TableConfigs map[string]*TableConfig `mapstructure:"TableConfigs"`
 	*MaxWell     `mapstructure:"MaxWell"`
+	*Misc        `mapstructure:"Misc"`
+
+	redactor *redact.Redactor
 }
 
 type DBConfig struct {
@@ -20,6 +30,12 @@ type DBConfig struct {
 	Disable  bool   `mapstructure:"Disable"`
 }
 
+type Misc struct {
+	ListenAddr string       `mapstructure:"ListenAddr"` // 管理接口, 为空时不启动
+	Auth       *auth.Config `mapstructure:"Auth"`       // optional
+	Redact     *redact.Keys `mapstructure:"Redact"`     // optional
+}
+
 type MaxWell struct {
 	CacheSize    int      `mapstructure:"CacheSize"`
 	WithoutMeta  bool     `mapstructure:"WithoutMeta"`
@@ -27,13 +43,32 @@ type MaxWell struct {
 	Brokers      []string `mapstructure:"Brokers"`
 	Topic        string   `mapstructure:"Topic"`
 	Prefix       string   `mapstructure:"Prefix"`
+
+	// 没有保存的 offset 时从哪里开始消费: oldest, newest, time:<RFC3339>, binlog:<file>:<pos>
+	StartFrom      string `mapstructure:"StartFrom"`
+	ForceStartFrom bool   `mapstructure:"ForceStartFrom"` // 忽略保存的 offset
+
+	TxBatch      bool `mapstructure:"TxBatch"`      // 只在事务边界 (commit: true) 之后切分批次
+	MaxBatchSize int  `mapstructure:"MaxBatchSize"` // TxBatch 时批次的上限, 默认 CacheSize*10
+
+	// _log 中的 data 和 old 保存为对象, 可以按列搜索; 默认是 json 字符串.
+	// 已有的 _log 索引中这两个字段是字符串, 打开前需要换 Prefix 或者重建索引
+	SearchableLog bool `mapstructure:"SearchableLog"`
 }
 
 type TableConfig struct {
-	TableName  string        `mapstructure:"TableName"`
-	DisableRow bool          `mapstructure:"DisableRow"`
-	DisableLog bool          `mapstructure:"DisableLog"`
-	Retention  time.Duration `mapstructure:"Retention"`
+	TableName  string         `mapstructure:"TableName"`
+	DisableRow bool           `mapstructure:"DisableRow"`
+	DisableLog bool           `mapstructure:"DisableLog"`
+	Retention  time.Duration  `mapstructure:"Retention"`
+	Redact     []*redact.Rule `mapstructure:"Redact"` // 写入时脱敏
+
+	DeleteMode   string        `mapstructure:"DeleteMode"`   // hard, soft, tombstone-ttl, 默认 hard
+	TombstoneTTL time.Duration `mapstructure:"TombstoneTTL"` // tombstone-ttl 时软删除的文档保留多久, 默认 Retention
+
+	Normalize  *normalize.Config `mapstructure:"Normalize"`  // optional, 按表结构处理 decimal, datetime, json 和二进制列
+	Transforms []*transform.Step `mapstructure:"Transforms"` // optional, 按顺序改名, 删除, 转换和派生列
+	Script     *script.Config    `mapstructure:"Script"`     // optional, 用 lua 脚本修改, 丢弃或者拆分事件
 }
 
 func defaultConfig() *config {
@@ -53,6 +88,7 @@ func defaultConfig() *config {
 			Brokers:      []string{"192.168.100.181:9092"},
 			Prefix:       "maxwell",
 		},
+		Misc: &Misc{},
 	}
 }
 
@@ -72,9 +108,59 @@ func (c *config) TableConfig(table string) *TableConfig {
 	return c.TableConfigs["@default"]
 }
 
+// tombstoneTTL 返回软删除的文档保留多久, 0 表示不清除
+func (c *TableConfig) tombstoneTTL() time.Duration {
+	if c.DeleteMode != tombstone.TTL {
+		return 0
+	}
+	if c.TombstoneTTL > 0 {
+		return c.TombstoneTTL
+	}
+	return c.Retention
+}
+
 func (c *config) validateBasic() error {
-	_, err := sarama.ParseKafkaVersion(c.KafkaVersion)
-	return err
+	if _, err := sarama.ParseKafkaVersion(c.KafkaVersion); err != nil {
+		return err
+	}
+
+	if err := offsets.ValidateStartFrom(c.StartFrom); err != nil {
+		return err
+	}
+
+	redactor, err := redact.New(c.Redact)
+	if err != nil {
+		return err
+	}
+
+	for name, tc := range c.TableConfigs {
+		if err := redactor.Validate(tc.Redact); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		if err := tombstone.Validate(tc.DeleteMode); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		if tc.Normalize != nil {
+			if err := tc.Normalize.Validate(); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+
+		if err := transform.Validate(tc.Transforms); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		if tc.Script != nil {
+			if err := tc.Script.Validate(); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	c.redactor = redactor
+	return nil
 }
 
 func parseConfig(configFile string) (*config, error) {
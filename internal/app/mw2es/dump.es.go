@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+	"github.com/helloshiki/maxwell-output/pkg/tombstone"
+	"github.com/helloshiki/maxwell-output/pkg/txbatch"
 	"github.com/olivere/elastic"
 	"go.uber.org/zap"
 	"sort"
@@ -23,13 +27,27 @@ type timedDump struct {
 	cache  []*sarama.ConsumerMessage
 
 	*config
+	batcher *txbatch.Batcher
+
+	tombIndices map[string]time.Duration // tombstone-ttl 的索引, 定时清除软删除的文档
+	schemas     *schema.Registry
 }
 
 func (d *timedDump) Initialize() error {
 	d.cache = make([]*sarama.ConsumerMessage, 0, d.size)
-	return nil
+	d.tombIndices = make(map[string]time.Duration)
+	for name, tc := range d.TableConfigs {
+		if i := strings.IndexByte(name, '.'); i > 0 && tc.tombstoneTTL() > 0 {
+			d.tombIndices[indexName(d.Prefix, name[:i], name[i+1:])] = tc.tombstoneTTL()
+		}
+	}
+	if d.batcher == nil {
+		d.batcher = &txbatch.Batcher{Size: d.size}
+	}
+	return d.loadSchemas()
 }
 
+// LastOffset 返回最后写入的 offset, 没有保存过时返回 offsets.Unset
 func (d *timedDump) LastOffset() (int64, error) {
 	client, err := elastic.NewClient()
 	if err != nil {
@@ -42,13 +60,13 @@ func (d *timedDump) LastOffset() (int64, error) {
 	}
 
 	if !ok {
-		return -1, nil
+		return offsets.Unset, nil
 	}
 
 	resp, err := client.Get().Index(d.Prefix).Id("offset").Do(context.Background())
 	if err != nil {
 		if elastic.IsNotFound(err) {
-			return -1, nil
+			return offsets.Unset, nil
 		}
 		return -1, err
 	}
@@ -61,31 +79,35 @@ func (d *timedDump) LastOffset() (int64, error) {
 		return -1, err
 	}
 
-	n, err := strconv.ParseUint(r.Data, 10, 64)
-	return int64(n), err
+	return strconv.ParseInt(r.Data, 10, 64)
 }
 
 func (d *timedDump) Dump(msg *sarama.ConsumerMessage) error {
 	if msg == nil {
-		return d.flush()
+		return d.flush(d.batcher.Flush())
 	}
 
 	d.cache = append(d.cache, msg)
-	if len(d.cache) >= d.size {
-		return d.flush()
+	if n := d.batcher.Add(msg.Value); n > 0 {
+		return d.flush(n)
 	}
 
 	return nil
 }
 
-func (d *timedDump) flush() error {
-	if len(d.cache) == 0 {
+// flush 写入缓存的前 n 条, 剩下的是还没有结束的事务
+func (d *timedDump) flush(n int) error {
+	if n == 0 {
 		return nil
 	}
 
+	if d.batcher.Partial(n) {
+		d.logger.Warn(`split transaction`, zap.Int(`count`, n))
+	}
+
 	// {"database":"hsn","table":"user_info","pk.id":177} {"database":"hsn","table":"user_info","type":"update","ts":1568760500,"xid":9268369,"commit":true,"position":"master.000009:9275773","data":{"id":177,"parend_id":167,"direaction":"/83/167/177","mobile":null,"password":"6fdcb73f9875fbabc053219631550170bb69507dde4c2b75d4201a9c6ea366ce","trade_password":null,"salt":"vCnGWQ3HYeksE4n0CXwf","true_name":null,"nick_name":"HSN_2644517","deposit_usdt_address":"19YDXxdSzX8vgYRhzHqE25VLsNocxW2k8U","deposit_hsn_address":"0xabedbeb4fd032fd9fabb304902ec8ff88e9191e5","balance_usdt":0E-8,"balance_usdt_available":0E-8,"balance_usdt_freeze":0E-8,"balance_hsn":0E-8,"balance_hsn_available":0E-8,"balance_hsn_freeze":0E-8,"points":0E-8,"invite_code":"9794246","id_card":null,"team_name":null,"is_active":"no","partner_level":0,"inviteReward_hsn":0E-8,"inviteReward_usdt":0E-8,"version":0,"create_date":"2019-09-18 06:48:20","update_date":"2019-09-18 06:48:20","privilege_user":"no","mail":"Lola.blair62@gmail.com","protector_master_node":null,"gambling_status":null},"old":{"direaction":null,"deposit_usdt_address":null,"deposit_hsn_address":null}}
-	cacheSize := len(d.cache)
-	lastMsg := d.cache[cacheSize-1]
+	cacheSize := n
+	lastMsg := d.cache[n-1]
 
 	start := time.Now()
 	defer func() {
@@ -99,9 +121,17 @@ func (d *timedDump) flush() error {
 	}
 
 	bulk := client.Bulk()
-	for _, msg := range d.cache {
-		d.update(bulk, msg)
-		d.appendLog(bulk, msg)
+	for _, msg := range d.cache[:n] {
+		msgs, err := d.scriptMsg(d.transformMsg(d.normalizeMsg(msg)))
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			msg = d.redactMsg(msg)
+			d.update(bulk, msg)
+			d.appendLog(bulk, msg)
+		}
 	}
 
 	resp, err := bulk.Do(context.Background())
@@ -116,14 +146,20 @@ func (d *timedDump) flush() error {
 		return fmt.Errorf("bulk fail")
 	}
 
-	d.cache = make([]*sarama.ConsumerMessage, 0, d.size)
+	xid, hasXid := lastXid(d.cache[:n])
+	d.cache = append(make([]*sarama.ConsumerMessage, 0, d.size), d.cache[n:]...)
+	d.batcher.Done(n)
 	{
 		bs, _ := json.Marshal(lastMsg.Offset)
+		body := map[string]interface{}{"data": string(bs)}
+		if hasXid {
+			body["xid"] = xid
+		}
 		resp, err := client.Index().
 			Index(d.Prefix).
 			Type("_doc").
 			Id(`offset`).
-			BodyJson(map[string]interface{}{"data": string(bs)}).
+			BodyJson(body).
 			Do(context.Background())
 		if err != nil {
 			return err
@@ -137,6 +173,16 @@ func (d *timedDump) flush() error {
 	return nil
 }
 
+// lastXid 返回 msgs 中最后一个完整事务的 xid
+func lastXid(msgs []*sarama.ConsumerMessage) (int64, bool) {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if xid, ok := txbatch.Commit(msgs[i].Value); ok {
+			return xid, true
+		}
+	}
+	return 0, false
+}
+
 func (d *timedDump) appendLog(bulk *elastic.BulkService, msg *sarama.ConsumerMessage) {
 	var r struct {
 		Database string `json:"database"`
@@ -173,11 +219,13 @@ func (d *timedDump) appendLog(bulk *elastic.BulkService, msg *sarama.ConsumerMes
 	}
 	value["key"] = makeID(m)
 
-	bs, _ := json.Marshal(value["data"])
-	value["data"] = string(bs)
-	if v, ok := value["old"]; ok {
-		bs, _ = json.Marshal(v)
-		value["old"] = string(bs)
+	if !d.SearchableLog {
+		bs, _ := json.Marshal(value["data"])
+		value["data"] = string(bs)
+		if v, ok := value["old"]; ok {
+			bs, _ = json.Marshal(v)
+			value["old"] = string(bs)
+		}
 	}
 
 	req := elastic.NewBulkIndexRequest().Index(d.Prefix + "_log").Type("_doc").Id(key).Doc(value)
@@ -232,26 +280,6 @@ func makeID(m map[string]json.RawMessage) string {
 	return builder.String()
 }
 
-func flatten(raw map[string]json.RawMessage, field string) error {
-	value, ok := raw[field]
-	if !ok {
-		return nil
-	}
-
-	var m map[string]json.RawMessage
-	err := json.Unmarshal(value, &m)
-	if err != nil {
-		return err
-	}
-
-	delete(raw, field)
-	for k, v := range m {
-		raw[fmt.Sprintf("%s.%s", field, k)] = v
-	}
-
-	return nil
-}
-
 func (d *timedDump) update(bulk *elastic.BulkService, msg *sarama.ConsumerMessage) {
 	var raw map[string]json.RawMessage
 	if err := json.Unmarshal(msg.Value, &raw); err != nil {
@@ -277,9 +305,19 @@ func (d *timedDump) update(bulk *elastic.BulkService, msg *sarama.ConsumerMessag
 		index := indexName(d.Prefix, database, table)
 		key := makeID(m)
 		if typ == `"delete"` {
-			req := elastic.NewBulkDeleteRequest().Index(index).Type("_doc").Id(key)
-			bulk.Add(req)
-			return
+			tc := d.TableConfig(makeDBTable(database, table))
+			if !tombstone.IsSoft(tc.DeleteMode) {
+				req := elastic.NewBulkDeleteRequest().Index(index).Type("_doc").Id(key)
+				bulk.Add(req)
+				return
+			}
+
+			var ts int64
+			_ = json.Unmarshal(raw["ts"], &ts)
+			raw["data"] = tombstone.Mark(raw["data"], ts, msg.Offset)
+			if ttl := tc.tombstoneTTL(); ttl > 0 {
+				d.tombIndices[index] = ttl
+			}
 		}
 
 		var doc struct {
@@ -308,7 +346,9 @@ func (d *timedDump) update(bulk *elastic.BulkService, msg *sarama.ConsumerMessag
 
 		req := elastic.NewBulkIndexRequest().Index(index).Type("_doc").Id(key).Doc(doc)
 		bulk.Add(req)
-	case `"bootstrap-start"`, `"bootstrap-complete"`, `"table-create"`:
+	case `"table-create"`, `"table-alter"`, `"table-drop"`, `"database-create"`, `"database-alter"`, `"database-drop"`:
+		d.putSchema(bulk, msg)
+	case `"bootstrap-start"`, `"bootstrap-complete"`:
 	default:
 		panic(string(msg.Value))
 	}
@@ -331,6 +371,79 @@ func makeLogKey(database, table, timestamp string, offset int64) string {
 	return builder.String()
 }
 
+// ResetOffset 保存新的 offset 并记录审计, 由调用方停止和重启消费
+func (d *timedDump) ResetOffset(r *offsets.Rewind) error {
+	client, err := elastic.NewClient()
+	if err != nil {
+		return err
+	}
+
+	// rewind 到 0 时保存 -1, 重启后从 0 开始而不是按 StartFrom
+	bs, _ := json.Marshal(r.To - 1)
+	_, err = client.Index().
+		Index(d.Prefix).
+		Type("_doc").
+		Id(`offset`).
+		BodyJson(map[string]interface{}{"data": string(bs)}).
+		Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Index().Index(d.Prefix + "_audit").Type("_doc").BodyJson(r).Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	d.logger.Warn(`reset offset`, zap.Int64("from", r.From), zap.Int64("to", r.To),
+		zap.String("mode", r.Mode), zap.String("remote", r.Remote))
+	return nil
+}
+
+// Pending 返回还未写入的缓存条数
+func (d *timedDump) Pending() int {
+	return len(d.cache)
+}
+
+// Discard 丢弃还未写入的缓存
+func (d *timedDump) Discard() {
+	if len(d.cache) > 0 {
+		d.logger.Warn(`discard`, zap.Int(`count`, len(d.cache)))
+	}
+	d.cache = make([]*sarama.ConsumerMessage, 0, d.size)
+	d.batcher.Reset()
+}
+
+// PurgeDeleted 按 data._deleted_at 清除超过 TombstoneTTL 的软删除文档.
+// 只处理配置了表名或者本次运行中有过删除的索引
+func (d *timedDump) PurgeDeleted() error {
+	if len(d.tombIndices) == 0 {
+		return nil
+	}
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for index, ttl := range d.tombIndices {
+		q := elastic.NewRangeQuery("data." + tombstone.DeletedAt).Lt(now.Add(-ttl).Unix())
+		resp, err := client.DeleteByQuery(index).Query(q).ProceedOnVersionConflict().Do(context.Background())
+		if err != nil {
+			if elastic.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+
+		if resp.Deleted > 0 {
+			d.logger.Warn(`purge deleted`, zap.String("index", index), zap.Int64(`count`, resp.Deleted))
+		}
+	}
+	return nil
+}
+
 func (d *timedDump) ClearLog() error {
 	return nil
 }
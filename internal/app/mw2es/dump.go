@@ -1,11 +1,20 @@
 package mw2es
 
-import "github.com/Shopify/sarama"
+import (
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+)
 
 type Dump interface {
 	Initialize() error
 	LastOffset() (int64, error)
 	Dump(msg *sarama.ConsumerMessage) error
+	ResetOffset(r *offsets.Rewind) error
+	Discard()
+	Pending() int
+	PurgeDeleted() error
+	Schema(database, table string, at int64) []*schema.Version
 }
 
 type RowOpt struct {
@@ -0,0 +1,138 @@
+package mw2es
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/auth"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"time"
+)
+
+func (c *Server) startHttpServer() {
+	addr := c.ListenAddr
+	if len(addr) == 0 {
+		return
+	}
+
+	logger := c.logger.With(zap.String("sub", "http"))
+	authenticator := auth.New(c.Auth)
+	m := func(ctx *fasthttp.RequestCtx) {
+		path := string(ctx.Path())
+		logger.Sugar().Infof(`path: %s, args: %s`, path, auth.LogArgs(ctx.QueryArgs()))
+
+		client, err := c.authorize(authenticator, ctx, path)
+		if err != nil {
+			status := 401
+			if err == auth.ErrForbidden {
+				status = 403
+			}
+			ctx.Error(err.Error()+"\n", status)
+			return
+		}
+
+		switch path {
+		case "/admin/offset":
+			if !ctx.IsPost() {
+				last, err := c.dumper.LastOffset()
+				if err != nil {
+					ctx.Error(err.Error()+"\n", 500)
+					return
+				}
+
+				if last == offsets.Unset {
+					last = -1
+				}
+				res := map[string]int64{"partition": 0, "offset": last}
+				res["oldest"], _ = c.client.GetOffset(c.Topic, 0, sarama.OffsetOldest)
+				res["newest"], _ = c.client.GetOffset(c.Topic, 0, sarama.OffsetNewest)
+
+				bs, _ := json.Marshal(res)
+				_, _ = ctx.WriteString(string(bs))
+				_, _ = ctx.WriteString("\n")
+				return
+			}
+
+			r, err := offsets.ParseRewind(ctx.QueryArgs())
+			if err != nil {
+				ctx.Error(err.Error()+"\n", 400)
+				return
+			}
+
+			r.Remote, r.Time = ctx.RemoteAddr().String(), time.Now().Format(time.RFC3339)
+			if client != nil {
+				r.Remote = client.Name + "@" + r.Remote
+			}
+			req := &rewindReq{r: r, done: make(chan error, 1)}
+			c.rewinds <- req
+			if err := <-req.done; err != nil {
+				status := 500
+				if err == offsets.ErrTxOpen {
+					status = 409
+				}
+				ctx.Error(err.Error()+"\n", status)
+				return
+			}
+
+			bs, _ := json.Marshal(r)
+			_, _ = ctx.WriteString(string(bs))
+			_, _ = ctx.WriteString("\n")
+		case "/schema":
+			args := ctx.QueryArgs()
+			database, table := string(args.Peek(`db`)), string(args.Peek(`tb`))
+			if len(database) == 0 || len(table) == 0 {
+				ctx.Error("bad database/table\n", 500)
+				return
+			}
+
+			// at: unix 秒或者 RFC3339
+			var at int64
+			if s := string(args.Peek(`at`)); len(s) > 0 {
+				if t, err := time.Parse(time.RFC3339, s); err == nil {
+					at = t.Unix()
+				} else if at, err = strconv.ParseInt(s, 10, 64); err != nil {
+					ctx.Error("bad at\n", 500)
+					return
+				}
+			}
+
+			bs, _ := json.Marshal(c.dumper.Schema(database, table, at))
+			_, _ = ctx.WriteString(string(bs))
+			_, _ = ctx.WriteString("\n")
+		default:
+			ctx.Error("bad path: "+path, 500)
+		}
+	}
+
+	logger.Sugar().Infof(`listen %s`, addr)
+	ln, err := auth.Listen(c.Auth, addr)
+	if err != nil {
+		logger.Fatal(`listen fail`, zap.String("e", err.Error()))
+		os.Exit(1)
+	}
+
+	if err := fasthttp.Serve(ln, m); err != nil {
+		logger.Fatal(`listen fail`, zap.String("e", err.Error()))
+		os.Exit(1)
+	}
+}
+
+// authorize 认证, 目前只有管理接口, 要求 Admin; 没有配置认证时返回 nil
+func (c *Server) authorize(a *auth.Authenticator, ctx *fasthttp.RequestCtx, path string) (*auth.Client, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	client, err := a.Authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.Admin {
+		return nil, auth.ErrForbidden
+	}
+	return client, nil
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/helloshiki/maxwell-output/pkg/txbatch"
 	"go.uber.org/zap"
 	"log"
 	"os"
@@ -15,6 +17,14 @@ type Server struct {
 	configFile string
 	*config
 	dumper Dump
+
+	client  sarama.Client
+	rewinds chan *rewindReq
+}
+
+type rewindReq struct {
+	r    *offsets.Rewind
+	done chan error
 }
 
 type opts struct {
@@ -64,7 +74,15 @@ func (c *Server) dump() error {
 	}
 
 	// consumer []string{"192.168.100.181:9092"}
-	consumer, err := sarama.NewConsumer(c.Brokers, config)
+	client, err := sarama.NewClient(c.Brokers, config)
+	if err != nil {
+		logger.Fatal("create client error", zap.String("e", err.Error()))
+		return err
+	}
+
+	defer func() { _ = client.Close() }()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
 	if err != nil {
 		logger.Fatal("create consumer error", zap.String("e", err.Error()))
 		return err
@@ -76,6 +94,7 @@ func (c *Server) dump() error {
 		logger:  c.logger,
 		size:    c.CacheSize,
 		config:  c.config,
+		batcher: &txbatch.Batcher{Size: c.CacheSize, TxOnly: c.TxBatch, MaxSize: c.MaxBatchSize},
 	}
 
 	if err := dump.Initialize(); err != nil {
@@ -87,8 +106,11 @@ func (c *Server) dump() error {
 		return err
 	}
 
-	if offset < 0 {
-		offset = sarama.OffsetOldest
+	if offset == offsets.Unset || c.ForceStartFrom {
+		offset, err = offsets.StartOffset(client, consumer, c.Topic, 0, c.StartFrom)
+		if err != nil {
+			return err
+		}
 	} else {
 		offset++
 	}
@@ -99,10 +121,19 @@ func (c *Server) dump() error {
 		log.Fatal("ConsumePartition", zap.String("e", err.Error()))
 		return err
 	}
-	defer func() { _ = partitionConsumer.Close() }()
 
+	// rewind 在消费协程内切换 rewinder.PC
+	rewinder := &offsets.Rewinder{Client: client, Consumer: consumer, Store: dump, Topic: c.Topic, Start: offset, PC: partitionConsumer}
+	defer func() {
+		if rewinder.PC != nil {
+			_ = rewinder.PC.Close()
+		}
+	}()
+
+	c.client = client
+	c.rewinds = make(chan *rewindReq)
 	c.dumper = dump
-	//go c.startHttpServer()
+	go c.startHttpServer()
 
 	timeout := time.Second
 	tick := time.NewTicker(timeout)
@@ -122,16 +153,26 @@ func (c *Server) dump() error {
 			if err := dump.Dump(nil); err != nil {
 				panic(err)
 			}
-		case msg := <-partitionConsumer.Messages():
+		case msg := <-rewinder.PC.Messages():
 			lastFlush = now
 			if err := dump.Dump(msg); err != nil {
 				panic(err)
 			}
+		case req := <-c.rewinds:
+			err := rewinder.Rewind(req.r)
+			req.done <- err
+			if err != nil && rewinder.PC == nil {
+				logger.Fatal(`rewind`, zap.String("e", err.Error()))
+				os.Exit(1)
+			}
 		case <-delTick.C:
 			//if err := dump.ClearLog(); err != nil {
 			//	panic(err)
 			//}
-		case err := <-partitionConsumer.Errors():
+			if err := dump.PurgeDeleted(); err != nil {
+				logger.Warn(`purge deleted`, zap.String("e", err.Error()))
+			}
+		case err := <-rewinder.PC.Errors():
 			logger.Fatal(`kafka`, zap.String("e", err.Error()))
 			os.Exit(1)
 		}
@@ -0,0 +1,28 @@
+package mw2es
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+)
+
+// normalizeMsg 按表结构和 Normalize 配置处理消息中的 data 和 old, 在 transformMsg 之前调用
+func (d *timedDump) normalizeMsg(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	var r struct {
+		Database string `json:"database"`
+		Table    string `json:"table"`
+	}
+	if err := json.Unmarshal(msg.Value, &r); err != nil {
+		return msg
+	}
+
+	c := d.TableConfig(makeDBTable(r.Database, r.Table)).Normalize
+	if c == nil {
+		return msg
+	}
+
+	m := *msg
+	m.Value = c.Message(d.schemas.Def(schema.Name(r.Database, r.Table)), msg.Value)
+	return &m
+}
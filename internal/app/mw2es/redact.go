@@ -0,0 +1,31 @@
+package mw2es
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+)
+
+// redactMsg 按表的 Redact 规则处理消息中的 data 和 old, 在 update/appendLog 之前调用
+func (d *timedDump) redactMsg(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	if d.redactor == nil {
+		return msg
+	}
+
+	var r struct {
+		Database string `json:"database"`
+		Table    string `json:"table"`
+	}
+	if err := json.Unmarshal(msg.Value, &r); err != nil {
+		return msg
+	}
+
+	rules := d.TableConfig(makeDBTable(r.Database, r.Table)).Redact
+	if len(rules) == 0 {
+		return msg
+	}
+
+	m := *msg
+	m.Value = d.redactor.Message(rules, msg.Value)
+	return &m
+}
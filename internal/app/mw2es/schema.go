@@ -0,0 +1,80 @@
+package mw2es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+	"github.com/olivere/elastic"
+	"go.uber.org/zap"
+)
+
+// loadSchemas 从 <Prefix>_schema 加载表结构历史
+func (d *timedDump) loadSchemas() error {
+	d.schemas = schema.NewRegistry()
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		return err
+	}
+
+	index := d.Prefix + "_schema"
+	ok, err := client.IndexExists(index).Do(context.Background())
+	if err != nil || !ok {
+		return err
+	}
+
+	scroll := client.Scroll(index).Size(1000)
+	for {
+		resp, err := scroll.Do(context.Background())
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		for _, hit := range resp.Hits.Hits {
+			var v schema.Version
+			if err := json.Unmarshal(*hit.Source, &v); err != nil {
+				return err
+			}
+			d.schemas.Load(&v)
+		}
+	}
+}
+
+// putSchema 记录 ddl 产生的表结构版本, 文档 id 是 db.table-offset
+func (d *timedDump) putSchema(bulk *elastic.BulkService, msg *sarama.ConsumerMessage) {
+	versions, err := schema.Parse(msg.Value, msg.Offset)
+	if err != nil {
+		d.logger.Error(`bad ddl`, zap.Int64("offset", msg.Offset), zap.String("e", err.Error()))
+		return
+	}
+
+	for _, v := range versions {
+		if !d.schemas.Add(v) {
+			continue
+		}
+
+		id := fmt.Sprintf("%s-%012d", v.Table, v.Offset)
+		bulk.Add(elastic.NewBulkIndexRequest().Index(d.Prefix + "_schema").Type("_doc").Id(id).Doc(v))
+		d.logger.Info(`schema`, zap.String("table", v.Table), zap.Int("version", v.Version), zap.String("type", v.Type))
+	}
+}
+
+// Schema 返回表结构的全部版本, at 大于 0 时只返回当时生效的版本
+func (d *timedDump) Schema(database, table string, at int64) []*schema.Version {
+	name := schema.Name(database, table)
+	if at <= 0 {
+		return d.schemas.History(name)
+	}
+
+	if v := d.schemas.At(name, at); v != nil {
+		return []*schema.Version{v}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package mw2es
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/transform"
+)
+
+// transformMsg 按 Transforms 配置处理消息中的 data 和 old, 在 normalizeMsg 之后, scriptMsg 之前调用
+func (d *timedDump) transformMsg(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	var r struct {
+		Database string `json:"database"`
+		Table    string `json:"table"`
+	}
+	if err := json.Unmarshal(msg.Value, &r); err != nil {
+		return msg
+	}
+
+	steps := d.TableConfig(makeDBTable(r.Database, r.Table)).Transforms
+	if len(steps) == 0 {
+		return msg
+	}
+
+	m := *msg
+	m.Value = transform.Message(steps, msg.Value)
+	return &m
+}
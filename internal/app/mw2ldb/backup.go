@@ -0,0 +1,169 @@
+package mw2ldb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"go.uber.org/zap"
+)
+
+// 备份文件格式, 整体 gzip 压缩:
+//   magic | (uvarint(len(key)) key uvarint(len(value)) value)* | uvarint(0) | count(8 bytes) | crc32c(4 bytes)
+// crc32c 覆盖所有记录.
+const backupMagic = "MWLDB\x01"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Backup 基于快照把整个 leveldb (包括 key:offset) 写入 w
+func (d *timedDump) Backup(w io.Writer) error {
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	zw := gzip.NewWriter(w)
+	bw := bufio.NewWriter(zw)
+	if _, err := bw.WriteString(backupMagic); err != nil {
+		return err
+	}
+
+	var count uint64
+	crc := crc32.New(crcTable)
+	out := io.MultiWriter(bw, crc)
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	iter := snap.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+	for iter.Next() {
+		for _, bs := range [][]byte{iter.Key(), iter.Value()} {
+			n := binary.PutUvarint(buf, uint64(len(bs)))
+			if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+			if _, err := out.Write(bs); err != nil {
+				return err
+			}
+		}
+		count++
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 1+8+4)
+	binary.BigEndian.PutUint64(trailer[1:], count)
+	binary.BigEndian.PutUint32(trailer[9:], crc.Sum32())
+	if _, err := bw.Write(trailer); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	d.logger.Info(`backup`, zap.Uint64("count", count))
+	return zw.Close()
+}
+
+// restore 把备份恢复到 file, file 必须不存在; 校验失败时删除已写入的数据
+func restore(file string, r io.Reader) (count uint64, err error) {
+	if _, err := os.Stat(file); err == nil {
+		return 0, errors.Errorf("%s already exists", file)
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	br := bufio.NewReader(zr)
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != backupMagic {
+		return 0, errors.New("bad backup file")
+	}
+
+	ldb, err := leveldb.OpenFile(file, &opt.Options{ErrorIfExist: true})
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if cerr := ldb.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.RemoveAll(file)
+		}
+	}()
+
+	crc := crc32.New(crcTable)
+	buf := make([]byte, binary.MaxVarintLen64)
+	read := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+
+		_, _ = crc.Write(buf[:binary.PutUvarint(buf, n)])
+		bs := make([]byte, n)
+		if _, err := io.ReadFull(br, bs); err != nil {
+			return nil, err
+		}
+
+		_, _ = crc.Write(bs)
+		return bs, nil
+	}
+
+	batch := new(leveldb.Batch)
+	for {
+		if b, err := br.Peek(1); err != nil {
+			return 0, errors.Wrap(err, "truncated backup")
+		} else if b[0] == 0 { // 结束标记
+			_, _ = br.ReadByte()
+			break
+		}
+
+		key, err := read()
+		if err != nil {
+			return 0, errors.Wrap(err, "truncated backup")
+		}
+
+		value, err := read()
+		if err != nil {
+			return 0, errors.Wrap(err, "truncated backup")
+		}
+
+		batch.Put(key, value)
+		count++
+		if batch.Len() >= 1000 {
+			if err := ldb.Write(batch, nil); err != nil {
+				return 0, err
+			}
+			batch.Reset()
+		}
+	}
+
+	trailer := make([]byte, 8+4)
+	if _, err := io.ReadFull(br, trailer); err != nil {
+		return 0, errors.Wrap(err, "truncated backup")
+	}
+
+	if binary.BigEndian.Uint64(trailer) != count || binary.BigEndian.Uint32(trailer[8:]) != crc.Sum32() {
+		return 0, errors.New("backup checksum mismatch")
+	}
+
+	if err := ldb.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,95 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// regzip 解压备份, 用 f 修改后重新压缩
+func regzip(t *testing.T, backup []byte, f func([]byte) []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, _ = zw.Write(f(raw))
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func TestBackupRestore(t *testing.T) {
+	d := newTestDump(t)
+	for i := 1; i <= 3; i++ {
+		if err := d.Dump(testMsg(int64(i), i, "insert", 1000, `{"id":1}`, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Dump(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := &bytes.Buffer{}
+	if err := d.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := path.Join(dir, "ok")
+	count, err := restore(file, bytes.NewReader(backup.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ldb, err := leveldb.OpenFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ldb.Close() }()
+
+	var n uint64
+	iter := ldb.NewIterator(nil, nil)
+	for iter.Next() {
+		v, err := d.ldb.Get(iter.Key(), nil)
+		if err != nil || !bytes.Equal(v, iter.Value()) {
+			t.Errorf("%s: value mismatch", iter.Key())
+		}
+		n++
+	}
+	iter.Release()
+	if n != count || n == 0 {
+		t.Errorf("restored %d keys, reported %d", n, count)
+	}
+
+	for name, bs := range map[string][]byte{
+		"truncated": regzip(t, backup.Bytes(), func(raw []byte) []byte { return raw[:len(raw)/2] }),
+		"crc": regzip(t, backup.Bytes(), func(raw []byte) []byte {
+			raw[len(raw)-1] ^= 0xff
+			return raw
+		}),
+	} {
+		file := path.Join(dir, name)
+		if _, err := restore(file, bytes.NewReader(bs)); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s: expect %s to be removed", name, file)
+		}
+	}
+}
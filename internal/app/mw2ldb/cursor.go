@@ -0,0 +1,58 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// cursor 是上一页最后一条记录的 key, base64 编码后对调用方不透明
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string, prefix []byte) ([]byte, error) {
+	if len(cursor) == 0 {
+		return nil, nil
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !bytes.HasPrefix(key, prefix) {
+		return nil, badRequest(`bad cursor`)
+	}
+	return key, nil
+}
+
+// seek 定位第一条要返回的记录, 返回是否有记录以及后续的移动方法.
+// 有 cursor 时从 cursor 之后 (倒序时之前) 开始, 否则从 start 开始, start 为空时从头 (倒序时从尾) 开始.
+func seek(iter iterator.Iterator, reverse bool, cursor, start []byte) (bool, func() bool) {
+	if !reverse {
+		switch {
+		case len(cursor) > 0:
+			ok := iter.Seek(cursor)
+			if ok && bytes.Equal(iter.Key(), cursor) {
+				ok = iter.Next()
+			}
+			return ok, iter.Next
+		case len(start) > 0:
+			return iter.Seek(start), iter.Next
+		}
+		return iter.First(), iter.Next
+	}
+
+	key, inclusive := cursor, false
+	if len(key) == 0 {
+		key, inclusive = start, true
+	}
+
+	if len(key) == 0 || !iter.Seek(key) {
+		return iter.Last(), iter.Prev
+	}
+
+	if inclusive && bytes.Equal(iter.Key(), key) {
+		return true, iter.Prev
+	}
+	return iter.Prev(), iter.Prev
+}
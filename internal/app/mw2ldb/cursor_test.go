@@ -0,0 +1,52 @@
+package mw2ldb
+
+import (
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestSeek(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ldb.Close() }()
+
+	for _, k := range []string{"a", "c", "e"} {
+		_ = ldb.Put([]byte(k), nil, nil)
+	}
+
+	cases := []struct {
+		reverse       bool
+		cursor, start string
+		expect        string
+	}{
+		{false, "", "", "ace"},
+		{false, "a", "", "ce"},
+		{false, "b", "", "ce"},
+		{false, "e", "", ""},
+		{false, "", "c", "ce"},
+		{true, "", "", "eca"},
+		{true, "e", "", "ca"},
+		{true, "d", "", "ca"},
+		{true, "a", "", ""},
+		{true, "", "c", "ca"},
+		{true, "", "d", "ca"},
+		{true, "", "f", "eca"},
+	}
+
+	for _, c := range cases {
+		iter := ldb.NewIterator(nil, nil)
+		var got string
+		for ok, step := seek(iter, c.reverse, []byte(c.cursor), []byte(c.start)); ok; ok = step() {
+			got += string(iter.Key())
+		}
+		iter.Release()
+
+		if got != c.expect {
+			t.Errorf("%+v: got %q", c, got)
+		}
+	}
+}
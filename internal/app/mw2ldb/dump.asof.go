@@ -0,0 +1,248 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/helloshiki/maxwell-output/pkg/tombstone"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+type histEvent struct {
+	Type string                     `json:"type"`
+	Ts   int64                      `json:"ts"`
+	Data map[string]json.RawMessage `json:"data"`
+	Old  map[string]json.RawMessage `json:"old"`
+}
+
+// undo 返回 e 发生之前的行, nil 表示行不存在
+func (e *histEvent) undo() map[string]json.RawMessage {
+	switch e.Type {
+	case "insert", "bootstrap-insert":
+		return nil
+	case "update":
+		row := make(map[string]json.RawMessage, len(e.Data))
+		for k, v := range e.Data {
+			row[k] = v
+		}
+		for k, v := range e.Old {
+			row[k] = v
+		}
+		return row
+	default: // delete
+		return e.Data
+	}
+}
+
+// rewind 从当前行出发, 由新到旧撤销 ts 晚于 at 的变更
+func rewind(row map[string]json.RawMessage, events []*histEvent, at int64) map[string]json.RawMessage {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Ts <= at {
+			break
+		}
+		row = events[i].undo()
+	}
+	return row
+}
+
+func (d *timedDump) checkRetention(database, table string, at time.Time) error {
+	buf := &bytes.Buffer{}
+	c := d.TableConfig(makeDBTable(buf, []byte(database), []byte(table)).String())
+	if c.Retention > 0 && at.Before(time.Now().Add(-c.Retention)) {
+		return badRequest(`at is out of retention`)
+	}
+	return nil
+}
+
+// currentRow 读取行的当前值, 不存在时返回 nil
+func (d *timedDump) currentRow(r leveldb.Reader, key []byte) (map[string]json.RawMessage, error) {
+	v, err := r.Get(key, &opt.ReadOptions{DontFillCache: true})
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return d.decodeRow(v)
+}
+
+// decodeRow 解析 row: 的值, 去掉 meta; 软删除的行返回 nil
+func (d *timedDump) decodeRow(v []byte) (map[string]json.RawMessage, error) {
+	if d.config.WithoutMeta {
+		var row map[string]json.RawMessage
+		err := json.Unmarshal(v, &row)
+		if tombstone.IsDeleted(row) {
+			return nil, err
+		}
+		return row, err
+	}
+
+	var row struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	err := json.Unmarshal(v, &row)
+	if tombstone.IsDeleted(row.Data) {
+		return nil, err
+	}
+	return row.Data, err
+}
+
+// rowAsOf 还原单行在 at 时刻的值, 只返回 data
+func (d *timedDump) rowAsOf(opts RowOpt) ([]interface{}, error) {
+	at, err := parseTime(opts.At, opts.TZ, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.checkRetention(opts.Database, opts.Table, at); err != nil {
+		return nil, err
+	}
+
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	buf := &bytes.Buffer{}
+	makeRowKey(buf, []byte(opts.Database), []byte(opts.Table), []byte(opts.PkID))
+	key := buf.String()
+
+	row, err := d.currentRow(snap, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	buf.Reset()
+	makeHistKey(buf, []byte(opts.Database), []byte(opts.Table), []byte(opts.PkID), -1)
+	iter := snap.NewIterator(util.BytesPrefix(buf.Bytes()), &opt.ReadOptions{DontFillCache: true})
+
+	var events []*histEvent
+	for iter.Next() {
+		var e histEvent
+		if err := json.Unmarshal(iter.Value(), &e); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		if e.Ts > at.Unix() {
+			events = append(events, &e)
+		}
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	row = rewind(row, events, at.Unix())
+	if row == nil {
+		return nil, leveldb.ErrNotFound
+	}
+
+	d.queryRedact(opts.Database, opts.Table, opts.Reveal).apply(row)
+
+	return []interface{}{record{key, row}}, nil
+}
+
+// TableAsOf 把整张表还原到 at 时刻, 以 ndjson 写入 w
+func (d *timedDump) TableAsOf(opts RowOpt, w io.Writer) error {
+	at, err := parseTime(opts.At, opts.TZ, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := d.checkRetention(opts.Database, opts.Table, at); err != nil {
+		return err
+	}
+
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	// 先找出 at 之后有变更的行, 按主键还原
+	buf := &bytes.Buffer{}
+	makeHistKey(buf, []byte(opts.Database), []byte(opts.Table), nil, -1)
+	prefix := buf.Len()
+
+	changed := make(map[string][]*histEvent)
+	iter := snap.NewIterator(util.BytesPrefix(buf.Bytes()), &opt.ReadOptions{DontFillCache: true})
+	for iter.Next() {
+		k := iter.Key()
+		pkID := string(k[prefix:bytes.LastIndexByte(k, '-')])
+
+		var e histEvent
+		if err := json.Unmarshal(iter.Value(), &e); err != nil {
+			iter.Release()
+			return err
+		}
+		if e.Ts > at.Unix() {
+			changed[pkID] = append(changed[pkID], &e)
+		}
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	rd := d.queryRedact(opts.Database, opts.Table, opts.Reveal)
+	enc := json.NewEncoder(w)
+	emit := func(key string, row map[string]json.RawMessage) error {
+		if row == nil {
+			return nil
+		}
+
+		rd.apply(row)
+		return enc.Encode(record{key, row})
+	}
+
+	buf.Reset()
+	makeRowKey(buf, []byte(opts.Database), []byte(opts.Table), nil)
+	prefix = buf.Len()
+
+	iter = snap.NewIterator(util.BytesPrefix(buf.Bytes()), &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+	for iter.Next() {
+		key := string(iter.Key())
+		row, err := d.decodeRow(iter.Value())
+		if err != nil {
+			return err
+		}
+
+		if events, ok := changed[key[prefix:]]; ok {
+			delete(changed, key[prefix:])
+			row = rewind(row, events, at.Unix())
+		}
+
+		if err := emit(key, row); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	// 剩下的是 at 之后被删除的行
+	pks := make([]string, 0, len(changed))
+	for pkID := range changed {
+		pks = append(pks, pkID)
+	}
+	sort.Strings(pks)
+
+	for _, pkID := range pks {
+		if err := emit(buf.String()[:prefix]+pkID, rewind(nil, changed[pkID], at.Unix())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
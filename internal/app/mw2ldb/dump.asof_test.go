@@ -0,0 +1,28 @@
+package mw2ldb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRewind(t *testing.T) {
+	var events []*histEvent
+	for _, s := range []string{
+		`{"type":"insert","ts":100,"data":{"id":1,"a":"x"}}`,
+		`{"type":"update","ts":200,"data":{"id":1,"a":"y"},"old":{"a":"x"}}`,
+		`{"type":"delete","ts":300,"data":{"id":1,"a":"y"}}`,
+	} {
+		var e histEvent
+		if err := json.Unmarshal([]byte(s), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, &e)
+	}
+
+	for at, want := range map[int64]string{50: ``, 150: `"x"`, 250: `"y"`, 350: ``} {
+		row := rewind(nil, events, at)
+		if got := string(row["a"]); got != want {
+			t.Errorf("at %d: got %s, want %s", at, got, want)
+		}
+	}
+}
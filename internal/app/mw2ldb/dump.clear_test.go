@@ -0,0 +1,94 @@
+package mw2ldb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// newTestDump 在临时目录中打开一个 leveldb
+func newTestDump(t *testing.T) *timedDump {
+	dir, err := ioutil.TempDir("", "mw2ldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig()
+	c.WithoutMeta = true
+	d := &timedDump{logger: zap.NewNop(), size: 100, config: c, dir: dir, ldbName: "ldb"}
+	if err := d.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = d.ldb.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return d
+}
+
+// testMsg 生成 hsn.user_info 的 maxwell 消息, old 为空时没有 old
+func testMsg(offset int64, pk int, typ string, ts int64, data, old string) *sarama.ConsumerMessage {
+	v := fmt.Sprintf(`{"database":"hsn","table":"user_info","type":"%s","ts":%d,"xid":%d,"commit":true,"data":%s`, typ, ts, offset, data)
+	if len(old) > 0 {
+		v += `,"old":` + old
+	}
+	v += "}"
+
+	return &sarama.ConsumerMessage{
+		Offset: offset,
+		Key:    []byte(fmt.Sprintf(`{"database":"hsn","table":"user_info","pk.id":%d}`, pk)),
+		Value:  []byte(v),
+	}
+}
+
+func TestClearLog(t *testing.T) {
+	d := newTestDump(t)
+	d.TableConfigs["hsn.user_info"] = &TableConfig{Retention: time.Hour}
+
+	old, now := time.Now().Add(-2*time.Hour).Unix(), time.Now().Unix()
+	for _, msg := range []*sarama.ConsumerMessage{
+		testMsg(1, 1, "insert", old, `{"id":1,"n":1}`, ""),
+		testMsg(2, 2, "insert", old, `{"id":2,"n":1}`, ""),
+		testMsg(3, 1, "update", old, `{"id":1,"n":2}`, `{"n":1}`),
+		testMsg(4, 1, "update", now, `{"id":1,"n":3}`, `{"n":2}`),
+	} {
+		if err := d.Dump(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Dump(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.ClearLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, _, err := d.QueryLog(LogOpt{Database: "hsn", Table: "user_info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || keyOffset([]byte(r[0].(record).K)) != 4 {
+		t.Errorf("expect only the log of offset 4, got %v", r)
+	}
+
+	for pk, want := range map[string]int{"1": 1, "2": 0} {
+		r, _, err := d.QueryHistory(HistOpt{Database: "hsn", Table: "user_info", PkID: pk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r) != want {
+			t.Errorf("pk %s: expect %d history, got %d", pk, want, len(r))
+		}
+	}
+
+	if _, ok := d.tables["hsn.user_info"]; !ok {
+		t.Error("expect the table to be kept in logtables")
+	}
+}
@@ -0,0 +1,156 @@
+package mw2ldb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// Export 基于快照把整张表的 row:, 或者 [BeginTime, EndTime) 内的 log:, 流式写入 w.
+// csv 的列按 table-create 的定义排列, 没有定义时取第一条记录的列; log 额外输出 _type 和 _ts 两列.
+func (d *timedDump) Export(opts ExportOpt, w io.Writer) error {
+	if len(opts.Format) == 0 {
+		opts.Format = FormatNDJSON
+	}
+
+	if opts.Format != FormatNDJSON && opts.Format != FormatCSV {
+		return badRequest(`bad format`)
+	}
+
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	def := d.loadDef(opts.Database, opts.Table)
+	database, table := []byte(opts.Database), []byte(opts.Table)
+	buf := &bytes.Buffer{}
+	var rg *util.Range
+	if opts.Log {
+		opts.BeginTime, opts.EndTime, err = logRange(opts.BeginTime, opts.EndTime, opts.TZ)
+		if err != nil {
+			return err
+		}
+
+		makeLogKey(buf, database, table, opts.BeginTime, 0)
+		start := append([]byte(nil), buf.Bytes()...)
+		buf.Reset()
+		makeLogKey(buf, database, table, opts.EndTime, 0)
+		rg = &util.Range{Start: start, Limit: buf.Bytes()}
+	} else {
+		makeRowKey(buf, database, table, nil)
+		rg = util.BytesPrefix(buf.Bytes())
+	}
+
+	rd := d.queryRedact(opts.Database, opts.Table, opts.Reveal)
+	f := &filter{redact: rd}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	cw := csv.NewWriter(bw)
+	var columns []string
+
+	iter := snap.NewIterator(rg, &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+	for iter.Next() {
+		if !opts.Log && isDeletedRow(iter.Value(), d.config.WithoutMeta) {
+			continue
+		}
+
+		if opts.Format == FormatNDJSON {
+			var v interface{} = json.RawMessage(iter.Value())
+			if rd != nil {
+				var r map[string]json.RawMessage
+				_ = json.Unmarshal(iter.Value(), &r)
+				if opts.Log {
+					r, _ = f.log(r)
+				} else {
+					r, _ = f.row(r, d.config.WithoutMeta)
+				}
+				v = r
+			}
+
+			err := enc.Encode(struct {
+				K string      `json:"k"`
+				V interface{} `json:"v"`
+			}{string(iter.Key()), v})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var e sqlEvent
+		if opts.Log {
+			if err := json.Unmarshal(iter.Value(), &e); err != nil {
+				return err
+			}
+		} else if e.Data, err = d.decodeRow(iter.Value()); err != nil {
+			return err
+		}
+
+		rd.apply(e.Data)
+		if columns == nil {
+			columns = def.ColumnNames(e.Data)
+			header := columns
+			if opts.Log {
+				header = append([]string{"_type", "_ts"}, columns...)
+			}
+			if err := cw.Write(header); err != nil {
+				return err
+			}
+		}
+
+		record := make([]string, 0, len(columns)+2)
+		if opts.Log {
+			record = append(record, e.Type, strconv.FormatInt(e.Ts, 10))
+		}
+		for _, k := range columns {
+			record = append(record, csvValue(e.Data[k]))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// csvValue 把 json 值转成 csv 字段, null 为空
+func csvValue(v json.RawMessage) string {
+	if len(v) == 0 || string(v) == "null" {
+		return ""
+	}
+
+	switch v[0] {
+	case '"':
+		var s string
+		_ = json.Unmarshal(v, &s)
+		return s
+	case '{', '[':
+		buf := &bytes.Buffer{}
+		_ = json.Compact(buf, v)
+		return buf.String()
+	}
+	return string(v)
+}
@@ -0,0 +1,93 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// keyOffset 取出 log:/hist: 键末尾的 kafka offset
+func keyOffset(key []byte) int64 {
+	n, _ := strconv.ParseInt(string(key[bytes.LastIndexByte(key, '-')+1:]), 10, 64)
+	return n
+}
+
+// Flashback 按时间倒序输出 [BeginTime, EndTime) 内变更的回滚语句
+func (d *timedDump) Flashback(opts FlashbackOpt, w io.Writer) error {
+	var err error
+	opts.BeginTime, opts.EndTime, err = logRange(opts.BeginTime, opts.EndTime, opts.TZ)
+	if err != nil {
+		return err
+	}
+
+	begin, _ := time.ParseInLocation(TimestampFmt, opts.BeginTime, time.UTC)
+	end, _ := time.ParseInLocation(TimestampFmt, opts.EndTime, time.UTC)
+
+	if err := d.sqlNormalized(opts.Database, opts.Table); err != nil {
+		return err
+	}
+
+	if err := d.sqlTransformed(opts.Database, opts.Table, opts.AllowLossy); err != nil {
+		return err
+	}
+
+	if err := d.sqlScripted(opts.Database, opts.Table, opts.AllowLossy); err != nil {
+		return err
+	}
+
+	redact, err := d.sqlRedact(opts.Database, opts.Table, opts.Reveal, opts.AllowLossy)
+	if err != nil {
+		return err
+	}
+
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	def := d.loadDef(opts.Database, opts.Table)
+	var r *util.Range
+	startBuf, endBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	if len(opts.PkID) > 0 {
+		makeHistKey(startBuf, []byte(opts.Database), []byte(opts.Table), []byte(opts.PkID), -1)
+		r = util.BytesPrefix(startBuf.Bytes())
+	} else {
+		makeLogKey(startBuf, []byte(opts.Database), []byte(opts.Table), opts.BeginTime, 0)
+		makeLogKey(endBuf, []byte(opts.Database), []byte(opts.Table), opts.EndTime, 0)
+		r = &util.Range{Start: startBuf.Bytes(), Limit: endBuf.Bytes()}
+	}
+
+	s := &sqlWriter{database: opts.Database, table: opts.Table, def: def, dialect: DialectMySQL,
+		redact: redact}
+	buf := &bytes.Buffer{}
+	iter := snap.NewIterator(r, &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		var e sqlEvent
+		if err := json.Unmarshal(iter.Value(), &e); err != nil {
+			return err
+		}
+
+		if e.Ts < begin.Unix() || e.Ts >= end.Unix() {
+			continue
+		}
+
+		buf.Reset()
+		if !s.flashback(buf, &e) {
+			continue
+		}
+
+		sqlComment(buf, keyOffset(iter.Key()), &e)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return iter.Error()
+}
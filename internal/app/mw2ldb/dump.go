@@ -1,15 +1,43 @@
 package mw2ldb
 
-import "github.com/Shopify/sarama"
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+)
 
 type Dump interface {
 	Initialize() error
 	LastOffset() (int64, error)
 	Dump(msg *sarama.ConsumerMessage) error
 	ClearLog() error
-	QueryLog(opt LogOpt) (interface{}, error)
-	QueryRow(opt RowOpt) ([]interface{}, error)
-	ResetOffset(offset int) error
+	PurgeDeleted() error
+	QueryLog(opt LogOpt) ([]interface{}, string, error)
+	QueryRow(opt RowOpt) ([]interface{}, string, error)
+	QueryHistory(opt HistOpt) ([]interface{}, string, error)
+	QueryTx(opt TxOpt) ([]interface{}, error)
+	Schema(opt SchemaOpt) ([]*schema.Version, error)
+	Flashback(opt FlashbackOpt, w io.Writer) error
+	Export(opt ExportOpt, w io.Writer) error
+	Subscribe(opt SubscribeOpt, w *bufio.Writer) error
+	Watch(ctx context.Context, opt SubscribeOpt, send func(r *record, offset int64) error, idle func() error) error
+	Backup(w io.Writer) error
+	Tables(exact bool) ([]interface{}, error)
+	Stats() (map[string]interface{}, error)
+	ResetOffset(r *offsets.Rewind) error
+	Discard()
+	Pending() int
+}
+
+// record 是 QueryLog/QueryRow/QueryHistory 返回的元素, K 是 leveldb 的键
+type record struct {
+	K string                     `json:"k"`
+	V map[string]json.RawMessage `json:"v"`
 }
 
 type RowOpt struct {
@@ -18,14 +46,83 @@ type RowOpt struct {
 	PkID     string // optional
 	Offset   int    // optional
 	Limit    int    // optional
+	At       string // optional, 见 parseTime
+	Cursor   string // optional
+	Reverse  bool   // optional
+	Where    string // optional, is_active:yes,partner_level>=2
+	Fields   string // optional, id,name
+	Reveal   bool   // optional, 解密 encrypt 的列
+	TZ       string // optional, At 为 20060102150405 格式时的时区, 默认本地时区
+
+	IncludeDeleted bool // optional, 包括软删除的行
+}
+
+type SchemaOpt struct {
+	Database string
+	Table    string
+	At       string // optional, 见 parseTime
+	TZ       string // optional
 }
 
 type LogOpt struct {
 	Database  string
 	Table     string
-	BeginTime string // optional
+	BeginTime string // optional, 见 parseTime
 	EndTime   string // optional
+	TZ        string // optional, 时间为 20060102150405 格式时的时区, 默认本地时区
+	Position  string // optional, master.000009:9275773, 从该 binlog 位置开始, 不能和 BeginTime/EndTime 一起使用
 	Offset    int    // optional
 	Limit     int    // optional
-	Reverse bool  // optional
+	Reverse   bool   // optional
+	Cursor    string // optional
+	Where     string // optional, is_active:yes,partner_level>=2
+	Type      string // optional, insert,update
+	Changed   string // optional, columns in old
+	Fields    string // optional, id,name
+	Reveal    bool   // optional, 解密 encrypt 的列
+}
+
+type HistOpt struct {
+	Database string
+	Table    string
+	PkID     string
+	Offset   int    // optional
+	Limit    int    // optional
+	Reverse  bool   // optional
+	Cursor   string // optional
+	Reveal   bool   // optional, 解密 encrypt 的列
+}
+
+type FlashbackOpt struct {
+	Database   string
+	Table      string
+	BeginTime  string // optional, 见 parseTime
+	EndTime    string // optional
+	TZ         string // optional
+	PkID       string // optional
+	Reveal     bool   // optional, 解密 encrypt 的列, 为 false 时 encrypt 的列也无法还原
+	AllowLossy bool   // optional, 允许为有 drop/hash/mask 规则, Transforms 或者 Script 的表生成 sql, 这些列无法还原
+}
+
+type SQLExportOpt struct {
+	Database   string
+	Table      string // optional, all tables of the database if empty
+	BeginTime  string // optional, 见 parseTime
+	EndTime    string // optional
+	TZ         string // optional
+	Dialect    string // optional, mysql or sqlite
+	Upsert     bool   // optional
+	Reveal     bool   // optional, 同 FlashbackOpt
+	AllowLossy bool   // optional, 同 FlashbackOpt
+}
+
+type ExportOpt struct {
+	Database  string
+	Table     string
+	Format    string // optional, ndjson or csv
+	Log       bool   // optional, export log: instead of row:
+	BeginTime string // optional, only for log, 见 parseTime
+	EndTime   string // optional, only for log
+	TZ        string // optional
+	Reveal    bool   // optional, 解密 encrypt 的列
 }
@@ -0,0 +1,249 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.uber.org/zap"
+)
+
+// log: 的二级索引, 值是 log: 键, 和 log: 一起写入和删除. 旧数据在第一次读写打开时补建, 完成后写入 key:logindex
+const logIndexKey = KeyPrefix + "logindex"
+
+type TxOpt struct {
+	Xid    int64
+	Reveal bool // optional, 解密 encrypt 的列
+}
+
+// makeXidKey 中 table 是 db.table. 脚本拆分出的事件共用 offset, 带上表名才不会互相覆盖
+func makeXidKey(buf *bytes.Buffer, xid int64, offset int64, table string) *bytes.Buffer {
+	buf.WriteString(XidPrefix)
+	buf.WriteString(fmt.Sprintf("%020d-", xid))
+	if offset >= 0 {
+		buf.WriteString(fmt.Sprintf("%012d", offset))
+		if len(table) > 0 {
+			buf.WriteString("-")
+			buf.WriteString(table)
+		}
+	}
+	return buf
+}
+
+// makePosKey 中 table 是 db.table, pos 补齐到 12 位, 同一个 binlog 文件内按位置排序
+func makePosKey(buf *bytes.Buffer, table, file string, pos int64, offset int64) *bytes.Buffer {
+	buf.WriteString(PosPrefix)
+	buf.WriteString(table)
+	buf.WriteString("-")
+	if len(file) > 0 {
+		buf.WriteString(fmt.Sprintf("%s:%012d-", file, pos))
+	}
+	if offset >= 0 {
+		buf.WriteString(fmt.Sprintf("%012d", offset))
+	}
+	return buf
+}
+
+// parsePosition 解析 maxwell 的 position: master.000009:9275773
+func parsePosition(s string) (string, int64, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i <= 0 {
+		return "", 0, badRequest("bad position %s", s)
+	}
+
+	pos, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil || pos < 0 {
+		return "", 0, badRequest("bad position %s", s)
+	}
+	return s[:i], pos, nil
+}
+
+// logIndexKeys 返回一条 log: 的二级索引键, table 是 db.table
+func logIndexKeys(table string, offset int64, value []byte) [][]byte {
+	var r struct {
+		Xid      *int64 `json:"xid"`
+		Position string `json:"position"`
+	}
+	if err := json.Unmarshal(value, &r); err != nil {
+		return nil
+	}
+
+	var keys [][]byte
+	if r.Xid != nil {
+		keys = append(keys, makeXidKey(&bytes.Buffer{}, *r.Xid, offset, table).Bytes())
+	}
+
+	if file, pos, err := parsePosition(r.Position); err == nil {
+		keys = append(keys, makePosKey(&bytes.Buffer{}, table, file, pos, offset).Bytes())
+	}
+	return keys
+}
+
+// legacyXidKey 返回旧版本没有表名的 xid: 键, 删除 log: 时一起删除
+func legacyXidKey(offset int64, value []byte) []byte {
+	var r struct {
+		Xid *int64 `json:"xid"`
+	}
+	if err := json.Unmarshal(value, &r); err != nil || r.Xid == nil {
+		return nil
+	}
+	return makeXidKey(&bytes.Buffer{}, *r.Xid, offset, "").Bytes()
+}
+
+// logKeyTable 从 log: 键中取出 db.table
+func logKeyTable(key []byte) string {
+	i := bytes.LastIndexByte(key, '-') - len(TimestampFmt) - 1
+	if i < len(LogPrefix) {
+		return ""
+	}
+	return string(key[len(LogPrefix):i])
+}
+
+// indexLogKeys 为没有索引的旧 log: 补建索引, 可以重复执行
+func (d *timedDump) indexLogKeys() error {
+	if _, err := d.ldb.Get([]byte(logIndexKey), nil); err != leveldb.ErrNotFound || d.readOnly {
+		if err == leveldb.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+
+	iter := d.ldb.NewIterator(util.BytesPrefix([]byte(LogPrefix)), &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+
+	count := 0
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		key := append([]byte(nil), iter.Key()...)
+		for _, k := range logIndexKeys(logKeyTable(key), keyOffset(key), iter.Value()) {
+			batch.Put(k, key)
+		}
+
+		if count++; batch.Len() >= 10000 {
+			if err := d.ldb.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	batch.Put([]byte(logIndexKey), []byte("xid,pos"))
+	if err := d.ldb.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		d.logger.Warn(`index log keys`, zap.Int(`count`, count))
+	}
+	return nil
+}
+
+// QueryTx 返回同一个 mysql 事务中所有表的变更, 按 kafka offset 排序
+func (d *timedDump) QueryTx(opts TxOpt) ([]interface{}, error) {
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	prefix := makeXidKey(&bytes.Buffer{}, opts.Xid, -1, "").Bytes()
+	iter := snap.NewIterator(util.BytesPrefix(prefix), &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+
+	var resArr []interface{}
+	for iter.Next() {
+		value, err := snap.Get(iter.Value(), &opt.ReadOptions{DontFillCache: true})
+		if err == leveldb.ErrNotFound { // 已经过期
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var r map[string]json.RawMessage
+		_ = json.Unmarshal(value, &r)
+
+		var database, table string
+		_ = json.Unmarshal(r["database"], &database)
+		_ = json.Unmarshal(r["table"], &table)
+
+		f := &filter{redact: d.queryRedact(database, table, opts.Reveal)}
+		r, _ = f.log(r)
+		resArr = append(resArr, record{string(iter.Value()), r})
+	}
+
+	return resArr, iter.Error()
+}
+
+// queryLogByPosition 从 binlog 位置开始 (reverse 时到该位置为止) 查询一张表的 log:, cursor 是 pos: 键
+func (d *timedDump) queryLogByPosition(opts LogOpt, f *filter) ([]interface{}, string, error) {
+	file, pos, err := parsePosition(opts.Position)
+	if err != nil {
+		return nil, "", err
+	}
+
+	buf := &bytes.Buffer{}
+	table := makeDBTable(buf, []byte(opts.Database), []byte(opts.Table)).String()
+	prefix := makePosKey(&bytes.Buffer{}, table, "", 0, -1).Bytes()
+	start := makePosKey(&bytes.Buffer{}, table, file, pos, -1).Bytes()
+	if opts.Reverse {
+		start = makePosKey(&bytes.Buffer{}, table, file, pos+1, -1).Bytes()
+	}
+
+	cursor, err := decodeCursor(opts.Cursor, prefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return nil, "", err
+	}
+	defer snap.Release()
+
+	iter := snap.NewIterator(util.BytesPrefix(prefix), &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+
+	var resArr []interface{}
+	var next, last string
+	skip, take := 0, 0
+	for ok, step := seek(iter, opts.Reverse, cursor, start); ok; ok = step() {
+		value, err := snap.Get(iter.Value(), &opt.ReadOptions{DontFillCache: true})
+		if err == leveldb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+
+		var r map[string]json.RawMessage
+		_ = json.Unmarshal(value, &r)
+		r, matched := f.log(r)
+		if !matched {
+			continue
+		}
+
+		skip++
+		if skip <= opts.Offset {
+			continue
+		}
+
+		if take >= opts.Limit {
+			next = encodeCursor(last)
+			break
+		}
+
+		take++
+		last = string(iter.Key())
+		resArr = append(resArr, record{string(iter.Value()), r})
+	}
+
+	return resArr, next, iter.Error()
+}
@@ -0,0 +1,29 @@
+package mw2ldb
+
+import (
+	"testing"
+)
+
+func TestLogIndexKeys(t *testing.T) {
+	value := []byte(`{"database":"hsn","table":"user_info","xid":12345,"commit":true,"position":"master.000009:9275773"}`)
+	keys := logIndexKeys("hsn.user_info", 64734, value)
+	if len(keys) != 2 {
+		t.Fatalf("expect 2 keys, got %d", len(keys))
+	}
+
+	if s := string(keys[0]); s != "xid:00000000000000012345-000000064734-hsn.user_info" {
+		t.Errorf("bad xid key %s", s)
+	}
+
+	if s := string(keys[1]); s != "pos:hsn.user_info-master.000009:000009275773-000000064734" {
+		t.Errorf("bad pos key %s", s)
+	}
+
+	if keys := logIndexKeys("hsn.user_info", 1, []byte(`{"type":"table-create"}`)); len(keys) != 0 {
+		t.Errorf("expect no keys, got %q", keys)
+	}
+
+	if s := logKeyTable([]byte("log:hsn.user_info-20191010040000-000000064734")); s != "hsn.user_info" {
+		t.Errorf("bad table %s", s)
+	}
+}
@@ -0,0 +1,28 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMakeKeys(t *testing.T) {
+	db, tb := []byte("hsn"), []byte("user_info")
+	for _, c := range []struct {
+		got  *bytes.Buffer
+		want string
+	}{
+		// database 为空时 table 已经是 db.table, 清理和查询用这种方式生成前缀
+		{makeDBTable(&bytes.Buffer{}, nil, []byte("hsn.user_info")), "hsn.user_info"},
+		{makeDBTable(&bytes.Buffer{}, db, tb), "hsn.user_info"},
+		{makeRowKey(&bytes.Buffer{}, nil, []byte("hsn.user_info"), nil), "row:hsn.user_info-"},
+		{makeRowKey(&bytes.Buffer{}, db, tb, []byte("177")), "row:hsn.user_info-000000000177"},
+		{makeLogKey(&bytes.Buffer{}, nil, []byte("hsn.user_info"), "", 0), "log:hsn.user_info-"},
+		{makeLogKey(&bytes.Buffer{}, db, tb, "20191010040000", 64734), "log:hsn.user_info-20191010040000-000000064734"},
+		{makeHistKey(&bytes.Buffer{}, nil, []byte("hsn.user_info"), nil, -1), "hist:hsn.user_info-"},
+		{makeHistKey(&bytes.Buffer{}, db, tb, []byte("177"), 64734), "hist:hsn.user_info-000000000177-000000064734"},
+	} {
+		if c.got.String() != c.want {
+			t.Errorf("got %s, want %s", c.got, c.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.uber.org/zap"
+)
+
+// 旧版本 log: 键中的时间是服务器本地时间, 现在是 UTC. 迁移完成后写入 key:logtime
+const logTimeKey = KeyPrefix + "logtime"
+
+// migrateLogKeys 按 value 中的 ts 重新生成 log: 键, 结果和本地时区无关, 中断后可以重新执行
+func (d *timedDump) migrateLogKeys() error {
+	if _, err := d.ldb.Get([]byte(logTimeKey), nil); err != leveldb.ErrNotFound {
+		return err
+	}
+
+	iter := d.ldb.NewIterator(util.BytesPrefix([]byte(LogPrefix)), &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+
+	if d.readOnly {
+		if iter.First() {
+			d.logger.Warn(`log keys are in local time, open once without read-only to migrate them to UTC`)
+		}
+		return iter.Error()
+	}
+
+	count := 0
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		key := migrateLogKey(iter.Key(), iter.Value())
+		if key == nil {
+			continue
+		}
+
+		batch.Delete(iter.Key())
+		batch.Put(key, iter.Value())
+		if count++; count%10000 == 0 {
+			if err := d.ldb.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	batch.Put([]byte(logTimeKey), []byte("UTC"))
+	if err := d.ldb.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+
+	d.logger.Warn(`migrate log keys to UTC`, zap.Int(`count`, count))
+	return nil
+}
+
+// migrateLogKey 返回 UTC 时间的新键, 不需要迁移时返回 nil
+func migrateLogKey(key, value []byte) []byte {
+	i := bytes.LastIndexByte(key, '-') - len(TimestampFmt)
+	if i < len(LogPrefix)+1 || key[i-1] != '-' {
+		return nil
+	}
+
+	var r struct {
+		Ts int64 `json:"ts"`
+	}
+	if err := json.Unmarshal(value, &r); err != nil || r.Ts == 0 {
+		return nil
+	}
+
+	ts := time.Unix(r.Ts, 0).UTC().Format(TimestampFmt)
+	if string(key[i:i+len(TimestampFmt)]) == ts {
+		return nil
+	}
+
+	newKey := append([]byte(nil), key...)
+	copy(newKey[i:], ts)
+	return newKey
+}
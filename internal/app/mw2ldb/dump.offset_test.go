@@ -0,0 +1,23 @@
+package mw2ldb
+
+import (
+	"testing"
+
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+)
+
+func TestResetOffset(t *testing.T) {
+	d := newTestDump(t)
+	if last, err := d.LastOffset(); err != nil || last != offsets.Unset {
+		t.Fatalf("expect unset, got %d, %v", last, err)
+	}
+
+	if err := d.ResetOffset(&offsets.Rewind{Mode: offsets.ModeOffset, Arg: "0", From: 10, To: 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// rewind 到 0 后重启应该从 0 开始, 而不是按 StartFrom
+	if last, err := d.LastOffset(); err != nil || last != -1 {
+		t.Errorf("expect -1, got %d, %v", last, err)
+	}
+}
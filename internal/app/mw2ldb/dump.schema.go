@@ -0,0 +1,115 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.uber.org/zap"
+)
+
+// 旧版本只在 key:def:db.table 保存最后一次 table-create 的 def
+const defPrefix = KeyPrefix + "def:"
+
+func makeSchemaKey(v *schema.Version) []byte {
+	return []byte(fmt.Sprintf("%s%s-%012d", SchemaPrefix, v.Table, v.Offset))
+}
+
+// loadSchemas 加载表结构历史, 可写时把旧的 key:def: 转换成版本 1
+func (d *timedDump) loadSchemas() error {
+	d.schemas = schema.NewRegistry()
+
+	iter := d.ldb.NewIterator(util.BytesPrefix([]byte(SchemaPrefix)), nil)
+	for iter.Next() {
+		var v schema.Version
+		if err := json.Unmarshal(iter.Value(), &v); err != nil {
+			iter.Release()
+			return err
+		}
+		d.schemas.Load(&v)
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	iter = d.ldb.NewIterator(util.BytesPrefix([]byte(defPrefix)), nil)
+	for iter.Next() {
+		v := &schema.Version{Table: string(iter.Key()[len(defPrefix):]), Type: "table-create"}
+		if err := json.Unmarshal(iter.Value(), &v.Def); err != nil {
+			iter.Release()
+			return err
+		}
+
+		if len(d.schemas.History(v.Table)) == 0 && d.schemas.Add(v) {
+			bs, _ := json.Marshal(v)
+			batch.Put(makeSchemaKey(v), bs)
+		}
+		batch.Delete(iter.Key())
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	if batch.Len() == 0 || d.readOnly {
+		return nil
+	}
+
+	d.logger.Warn(`migrate def`, zap.Int(`count`, batch.Len()))
+	return d.ldb.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// putSchema 记录 ddl 产生的表结构版本
+func (d *timedDump) putSchema(batch *leveldb.Batch, msg *sarama.ConsumerMessage) {
+	versions, err := schema.Parse(msg.Value, msg.Offset)
+	if err != nil {
+		d.logger.Error(`bad ddl`, zap.Int64("offset", msg.Offset), zap.String("e", err.Error()))
+		return
+	}
+
+	for _, v := range versions {
+		if !d.schemas.Add(v) {
+			continue
+		}
+
+		bs, _ := json.Marshal(v)
+		batch.Put(makeSchemaKey(v), bs)
+		d.logger.Info(`schema`, zap.String("table", v.Table), zap.Int("version", v.Version), zap.String("type", v.Type))
+	}
+}
+
+// loadDef 返回表当前的结构, 没有时返回 nil
+func (d *timedDump) loadDef(database, table string) *schema.Def {
+	buf := &bytes.Buffer{}
+	return d.schemas.Def(makeDBTable(buf, []byte(database), []byte(table)).String())
+}
+
+// Schema 返回表结构的全部版本, 指定 At 时只返回当时生效的版本
+func (d *timedDump) Schema(opts SchemaOpt) ([]*schema.Version, error) {
+	buf := &bytes.Buffer{}
+	table := makeDBTable(buf, []byte(opts.Database), []byte(opts.Table)).String()
+	if len(opts.At) == 0 {
+		return d.schemas.History(table), nil
+	}
+
+	at, err := parseTime(opts.At, opts.TZ, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	v := d.schemas.At(table, at.Unix())
+	if v == nil {
+		return nil, leveldb.ErrNotFound
+	}
+	return []*schema.Version{v}, nil
+}
@@ -0,0 +1,152 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// SQLExport 把 [BeginTime, EndTime) 内的变更按 kafka offset 顺序转成 sql, 同一 xid 放在一个事务中
+func (d *timedDump) SQLExport(opts SQLExportOpt, w io.Writer) error {
+	var err error
+	opts.BeginTime, opts.EndTime, err = logRange(opts.BeginTime, opts.EndTime, opts.TZ)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Dialect {
+	case "":
+		opts.Dialect = DialectMySQL
+	case DialectMySQL, DialectSQLite:
+	default:
+		return badRequest("bad dialect: %s", opts.Dialect)
+	}
+
+	tables := []string{opts.Table}
+	if len(opts.Table) == 0 {
+		tables = tables[:0]
+		for table := range d.tables {
+			if strings.HasPrefix(table, opts.Database+".") {
+				tables = append(tables, table[len(opts.Database)+1:])
+			}
+		}
+		sort.Strings(tables)
+	}
+
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	writers := make([]*sqlWriter, len(tables))
+	iters := make([]iterator.Iterator, len(tables))
+	valid := make([]bool, len(tables))
+	defer func() {
+		for _, iter := range iters {
+			if iter != nil {
+				iter.Release()
+			}
+		}
+	}()
+
+	for i, table := range tables {
+		if err := d.sqlNormalized(opts.Database, table); err != nil {
+			return err
+		}
+
+		if err := d.sqlTransformed(opts.Database, table, opts.AllowLossy); err != nil {
+			return err
+		}
+
+		if err := d.sqlScripted(opts.Database, table, opts.AllowLossy); err != nil {
+			return err
+		}
+
+		redact, err := d.sqlRedact(opts.Database, table, opts.Reveal, opts.AllowLossy)
+		if err != nil {
+			return err
+		}
+
+		writers[i] = &sqlWriter{database: opts.Database, table: table, def: d.loadDef(opts.Database, table), dialect: opts.Dialect, upsert: opts.Upsert,
+			redact: redact}
+
+		startBuf, endBuf := &bytes.Buffer{}, &bytes.Buffer{}
+		makeLogKey(startBuf, []byte(opts.Database), []byte(table), opts.BeginTime, 0)
+		makeLogKey(endBuf, []byte(opts.Database), []byte(table), opts.EndTime, 0)
+		iters[i] = snap.NewIterator(&util.Range{Start: startBuf.Bytes(), Limit: endBuf.Bytes()},
+			&opt.ReadOptions{DontFillCache: true})
+		valid[i] = iters[i].Next()
+	}
+
+	var xid int64
+	buf := &bytes.Buffer{}
+	for {
+		// 多张表按 offset 归并
+		n := -1
+		for i, iter := range iters {
+			if valid[i] && (n < 0 || keyOffset(iter.Key()) < keyOffset(iters[n].Key())) {
+				n = i
+			}
+		}
+
+		if n < 0 {
+			break
+		}
+
+		var e sqlEvent
+		if err := json.Unmarshal(iters[n].Value(), &e); err != nil {
+			return err
+		}
+
+		buf.Reset()
+		if xid != 0 && e.Xid != xid {
+			buf.WriteString("COMMIT;\n")
+			xid = 0
+		}
+
+		offset := keyOffset(iters[n].Key())
+		valid[n] = iters[n].Next()
+
+		var stmt bytes.Buffer
+		if !writers[n].replay(&stmt, &e) {
+			_, _ = w.Write(buf.Bytes())
+			continue
+		}
+
+		if xid == 0 && e.Xid != 0 {
+			xid = e.Xid
+			buf.WriteString(fmt.Sprintf("BEGIN; -- xid %d\n", xid))
+		}
+
+		buf.Write(stmt.Bytes())
+		sqlComment(buf, offset, &e)
+		if e.Commit && xid != 0 {
+			buf.WriteString("COMMIT;\n")
+			xid = 0
+		}
+
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	for _, iter := range iters {
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+
+	if xid != 0 { // 时间窗口截断了事务
+		_, err := w.Write([]byte("COMMIT;\n"))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+type TableStat struct {
+	Table       string          `json:"table"` // db.table
+	Rows        int64           `json:"rows"`
+	RowsExact   bool            `json:"rows_exact"`
+	RowsSize    int64           `json:"rows_size"` // ldb.SizeOf, 不包含内存中还未落盘的数据
+	Logs        int64           `json:"logs"`
+	LogsExact   bool            `json:"logs_exact"`
+	LogsSize    int64           `json:"logs_size"`
+	OldestLog   string          `json:"oldest_log,omitempty"`
+	NewestLog   string          `json:"newest_log,omitempty"`
+	Bootstrap   json.RawMessage `json:"bootstrap,omitempty"`
+	TableConfig *TableConfig    `json:"table_config"`
+	DBConfig    *DBConfig       `json:"db_config"`
+}
+
+type bootstrapState struct {
+	Status string `json:"status"` // started, complete
+	Ts     int64  `json:"ts"`
+	Offset int64  `json:"offset"`
+}
+
+func makeBootstrapKey(database, table []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(KeyPrefix + "bootstrap:")
+	makeDBTable(buf, database, table)
+	return buf.Bytes()
+}
+
+// putBootstrap 记录 bootstrap-start/bootstrap-complete
+func (d *timedDump) putBootstrap(batch *leveldb.Batch, raw map[string]json.RawMessage, msg *sarama.ConsumerMessage) {
+	var database, table string
+	_ = json.Unmarshal(raw["database"], &database)
+	_ = json.Unmarshal(raw["table"], &table)
+
+	s := bootstrapState{Status: "started", Offset: msg.Offset}
+	if string(raw["type"]) == `"bootstrap-complete"` {
+		s.Status = "complete"
+	}
+	_ = json.Unmarshal(raw["ts"], &s.Ts)
+
+	bs, _ := json.Marshal(s)
+	batch.Put(makeBootstrapKey([]byte(database), []byte(table)), bs)
+}
+
+// scanTables 跳跃扫描 prefix 下的所有 db.table
+func scanTables(r leveldb.Reader, prefix string, tables map[string]struct{}) error {
+	iter := r.NewIterator(util.BytesPrefix([]byte(prefix)), &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+
+	for ok := iter.First(); ok; {
+		key := iter.Key()[len(prefix):]
+		dot := bytes.IndexByte(key, '.')
+		sep := -1
+		if dot >= 0 {
+			sep = bytes.IndexByte(key[dot:], '-')
+		}
+
+		if sep < 0 {
+			ok = iter.Next()
+			continue
+		}
+
+		table := string(key[:dot+sep])
+		tables[table] = struct{}{}
+		ok = iter.Seek(util.BytesPrefix([]byte(prefix + table + "-")).Limit)
+	}
+
+	return iter.Error()
+}
+
+// countRange 统计范围内的记录数, exact 为 false 时按前 100 条的平均大小估算
+func (d *timedDump) countRange(r leveldb.Reader, rg *util.Range, exact bool) (count, size int64, isExact bool, err error) {
+	sizes, err := d.ldb.SizeOf([]util.Range{*rg})
+	if err != nil {
+		return 0, 0, false, err
+	}
+	size = sizes.Sum()
+
+	const sample = 100
+	var bytesSum int64
+	iter := r.NewIterator(rg, &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+	for iter.Next() {
+		count++
+		bytesSum += int64(len(iter.Key()) + len(iter.Value()))
+		if !exact && count >= sample {
+			break
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return 0, 0, false, err
+	}
+
+	if exact || count < sample {
+		return count, size, true, nil
+	}
+
+	if estimated := size * count / bytesSum; estimated > count {
+		count = estimated
+	}
+	return count, size, false, nil
+}
+
+// Tables 列出所有表及其统计, exact 为 true 时精确计数
+func (d *timedDump) Tables(exact bool) ([]interface{}, error) {
+	snap, err := d.ldb.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	names := make(map[string]struct{})
+	for _, prefix := range []string{RowPrefix, LogPrefix} {
+		if err := scanTables(snap, prefix, names); err != nil {
+			return nil, err
+		}
+	}
+
+	tables := make([]string, 0, len(names))
+	for table := range names {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	resArr := make([]interface{}, 0, len(tables))
+	buf := &bytes.Buffer{}
+	for _, table := range tables {
+		database := table[:bytes.IndexByte([]byte(table), '.')]
+		s := &TableStat{
+			Table:       table,
+			TableConfig: d.TableConfig(table),
+			DBConfig:    d.DBConfig(database),
+		}
+
+		buf.Reset()
+		makeRowKey(buf, nil, []byte(table), nil)
+		s.Rows, s.RowsSize, s.RowsExact, err = d.countRange(snap, util.BytesPrefix(buf.Bytes()), exact)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Reset()
+		makeLogKey(buf, nil, []byte(table), "", 0)
+		rg := util.BytesPrefix(buf.Bytes())
+		s.Logs, s.LogsSize, s.LogsExact, err = d.countRange(snap, rg, exact)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.Logs > 0 {
+			iter := snap.NewIterator(rg, &opt.ReadOptions{DontFillCache: true})
+			if iter.First() {
+				s.OldestLog = logKeyTime(iter.Key())
+			}
+			if iter.Last() {
+				s.NewestLog = logKeyTime(iter.Key())
+			}
+			iter.Release()
+		}
+
+		if v, err := snap.Get(makeBootstrapKey(nil, []byte(table)), nil); err == nil {
+			s.Bootstrap = v
+		}
+
+		resArr = append(resArr, s)
+	}
+
+	return resArr, nil
+}
+
+// logKeyTime 取出 log:db.table-20190912000052-000000064734 中的时间
+func logKeyTime(key []byte) string {
+	end := bytes.LastIndexByte(key, '-')
+	if end < 0 {
+		return ""
+	}
+
+	start := bytes.LastIndexByte(key[:end], '-')
+	return string(key[start+1 : end])
+}
+
+// Stats 返回 leveldb 的内部状态和磁盘占用
+func (d *timedDump) Stats() (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+	for _, name := range []string{"stats", "iostats", "writedelay", "sstables", "cachedblock", "openedtables", "alivesnaps", "aliveiters"} {
+		v, err := d.ldb.GetProperty("leveldb." + name)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = v
+	}
+
+	var usage int64
+	err := filepath.Walk(path.Join(d.dir, d.ldbName), func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			usage += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res["disk_usage"] = usage
+	if v, err := d.ldb.Get([]byte(KeyPrefix+"xid"), nil); err == nil {
+		res["last_xid"], _ = strconv.ParseInt(string(v), 10, 64)
+	}
+	return res, nil
+}
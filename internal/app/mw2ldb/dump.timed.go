@@ -6,22 +6,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+	"github.com/helloshiki/maxwell-output/pkg/tombstone"
+	"github.com/helloshiki/maxwell-output/pkg/txbatch"
 	"github.com/pkg/errors"
 	"github.com/syndtr/goleveldb/leveldb"
 	levelErrors "github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 	"go.uber.org/zap"
-	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	RowPrefix = "row:" // row:hsn-user_daily_payback-2225
-	LogPrefix = "log:" // log:hsn-user_daily_payback-20190912000052-64734
-	KeyPrefix = "key:" // key:offset
+	RowPrefix    = "row:"    // row:hsn-user_daily_payback-2225
+	LogPrefix    = "log:"    // log:hsn-user_daily_payback-20190912000052-64734, UTC
+	HistPrefix   = "hist:"   // hist:hsn.user_info-000000000177-000000064734
+	AuditPrefix  = "audit:"  // audit:offset-20191019012700.000000000
+	KeyPrefix    = "key:"    // key:offset
+	XidPrefix    = "xid:"    // xid:00000000000000012345-000000064734-hsn.user_info
+	PosPrefix    = "pos:"    // pos:hsn.user_info-master.000009:000009275773-000000064734
+	TombPrefix   = "tomb:"   // tomb:hsn.user_info-20190912000052-000000064734, UTC
+	SchemaPrefix = "schema:" // schema:hsn.user_info-000000064734
 )
 
 const (
@@ -40,6 +50,11 @@ type timedDump struct {
 	ldbName string
 	ldb     *leveldb.DB
 
+	readOnly bool // 只读打开, 用于只提供查询的场景
+	feed     *broker
+	batcher  *txbatch.Batcher
+	schemas  *schema.Registry
+
 	tables map[string]struct{}
 }
 
@@ -66,11 +81,14 @@ func (d *timedDump) loadLogTables() error {
 
 func (d *timedDump) Initialize() error {
 	d.cache = make([]*sarama.ConsumerMessage, 0, d.size)
+	if d.batcher == nil {
+		d.batcher = &txbatch.Batcher{Size: d.size}
+	}
 
 	file := path.Join(d.dir, d.ldbName)
 
-	ldb, err := leveldb.OpenFile(file, nil)
-	if _, corrupted := err.(*levelErrors.ErrCorrupted); corrupted {
+	ldb, err := leveldb.OpenFile(file, &opt.Options{ReadOnly: d.readOnly})
+	if _, corrupted := err.(*levelErrors.ErrCorrupted); corrupted && !d.readOnly {
 		ldb, err = leveldb.RecoverFile(file, nil) // 如果有冲突，则需要修复文件
 	}
 
@@ -85,15 +103,24 @@ func (d *timedDump) Initialize() error {
 		return err
 	}
 
-	return nil
+	if err := d.migrateLogKeys(); err != nil {
+		return err
+	}
+
+	if err := d.loadSchemas(); err != nil {
+		return err
+	}
+
+	return d.indexLogKeys()
 }
 
+// LastOffset 返回最后写入的 offset, 没有保存过时返回 offsets.Unset
 func (d *timedDump) LastOffset() (int64, error) {
 	key := KeyPrefix + "offset"
 	value, err := d.ldb.Get([]byte(key), &opt.ReadOptions{DontFillCache: true})
 	if err != nil {
 		if err == leveldb.ErrNotFound {
-			err = nil
+			return offsets.Unset, nil
 		}
 
 		return -1, err
@@ -105,45 +132,78 @@ func (d *timedDump) LastOffset() (int64, error) {
 
 func (d *timedDump) Dump(msg *sarama.ConsumerMessage) error {
 	if msg == nil {
-		return d.flush()
+		return d.flush(d.batcher.Flush())
 	}
 
 	d.cache = append(d.cache, msg)
-	if len(d.cache) >= d.size {
-		return d.flush()
+	if n := d.batcher.Add(msg.Value); n > 0 {
+		return d.flush(n)
 	}
 
 	return nil
 }
 
-func (d *timedDump) flush() error {
-	if len(d.cache) == 0 {
+// flush 写入缓存的前 n 条, 剩下的是还没有结束的事务
+func (d *timedDump) flush(n int) error {
+	if n == 0 {
 		return nil
 	}
 
+	if d.batcher.Partial(n) {
+		d.logger.Warn(`split transaction`, zap.Int(`count`, n))
+	}
+
 	batch := new(leveldb.Batch)
-	for _, msg := range d.cache {
-		d.update(batch, msg)
-		d.appendLog(batch, msg)
+	events := make([]*feedEvent, 0, n)
+	for _, msg := range d.cache[:n] {
+		msgs, err := d.scriptMsg(d.transformMsg(d.normalizeMsg(msg)))
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			msg = d.redactMsg(msg)
+			d.update(batch, msg)
+			if e := d.appendLog(batch, msg); e != nil {
+				events = append(events, e)
+			}
+		}
 	}
 
-	lastMsg := d.cache[len(d.cache)-1]
+	lastMsg := d.cache[n-1]
 
 	var buf = make([]byte, 8)
 	binary.BigEndian.PutUint64(buf, uint64(lastMsg.Offset))
 	batch.Put([]byte(KeyPrefix+"offset"), buf)
+	if xid, ok := lastXid(d.cache[:n]); ok {
+		batch.Put([]byte(KeyPrefix+"xid"), []byte(strconv.FormatInt(xid, 10)))
+	}
 
 	if err := d.ldb.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
 		return err
 	}
 
-	d.logger.Info(`save`, zap.Int(`count`, len(d.cache)), zap.Int64("offset", lastMsg.Offset))
+	d.logger.Info(`save`, zap.Int(`count`, n), zap.Int64("offset", lastMsg.Offset))
+	if d.feed != nil {
+		d.feed.publish(events)
+	}
 
-	d.cache = make([]*sarama.ConsumerMessage, 0, d.size)
+	d.cache = append(make([]*sarama.ConsumerMessage, 0, d.size), d.cache[n:]...)
+	d.batcher.Done(n)
 	return nil
 }
 
-func (d *timedDump) appendLog(batch *leveldb.Batch, msg *sarama.ConsumerMessage) {
+// lastXid 返回 msgs 中最后一个完整事务的 xid
+func lastXid(msgs []*sarama.ConsumerMessage) (int64, bool) {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if xid, ok := txbatch.Commit(msgs[i].Value); ok {
+			return xid, true
+		}
+	}
+	return 0, false
+}
+
+func (d *timedDump) appendLog(batch *leveldb.Batch, msg *sarama.ConsumerMessage) *feedEvent {
 	var r struct {
 		Database string `json:"database"`
 		Table    string `json:"table"`
@@ -154,25 +214,35 @@ func (d *timedDump) appendLog(batch *leveldb.Batch, msg *sarama.ConsumerMessage)
 		panic(err)
 	}
 
+	if len(r.Table) == 0 { // database-* 没有表
+		return nil
+	}
+
 	database, table := []byte(r.Database), []byte(r.Table)
 	buf := &bytes.Buffer{}
 	makeDBTable(buf, database, table)
 
 	c := d.TableConfig(buf.String())
 	if c.DisableRow {
-		return
+		return nil
 	}
 
-	t := time.Unix(r.Ts, 0)
+	e := &feedEvent{table: buf.String(), offset: msg.Offset, value: msg.Value}
+	t := time.Unix(r.Ts, 0).UTC()
 
 	buf.Reset()
 	key := makeLogKey(buf, database, table, t.Format(TimestampFmt), msg.Offset)
+	e.key = key.String()
 	batch.Put(key.Bytes(), msg.Value)
+	for _, k := range logIndexKeys(e.table, msg.Offset, msg.Value) {
+		batch.Put(k, key.Bytes())
+	}
+	d.appendHist(batch, msg)
 
 	buf.Reset()
 	makeDBTable(buf, database, table)
 	if _, ok := d.tables[buf.String()]; ok {
-		return
+		return e
 	}
 
 	d.tables[buf.String()] = struct{}{}
@@ -183,6 +253,53 @@ func (d *timedDump) appendLog(batch *leveldb.Batch, msg *sarama.ConsumerMessage)
 
 	bs, _ := json.Marshal(tables)
 	batch.Put([]byte(KeyPrefix+"logtables"), bs)
+	return e
+}
+
+// appendHist 按主键记录变更历史, 联合主键及 ddl 等无主键的消息忽略
+func (d *timedDump) appendHist(batch *leveldb.Batch, msg *sarama.ConsumerMessage) {
+	database, table, pkID, err := parseKey(msg.Key)
+	if err != nil {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	makeHistKey(buf, []byte(database), []byte(table), pkID, msg.Offset)
+	batch.Put(buf.Bytes(), msg.Value)
+}
+
+var errIgnorePK = errors.New("ignore pk")
+
+// parseKey 解析 maxwell 的 key: {"database":"hsn","table":"user_info","pk.id":177}
+func parseKey(key []byte) (database, table string, pkID []byte, err error) {
+	var m map[string]json.RawMessage
+	if err = json.Unmarshal(key, &m); err != nil {
+		return
+	}
+
+	if len(m) != 3 {
+		err = errIgnorePK
+		return
+	}
+
+	_ = json.Unmarshal(m["database"], &database)
+	_ = json.Unmarshal(m["table"], &table)
+
+	for k, v := range m {
+		if strings.HasPrefix(k, "pk.") {
+			if bytes.HasPrefix(v, []byte("\"")) {
+				pkID = v[1 : len(v)-1]
+			} else {
+				pkID = v
+			}
+			break
+		}
+	}
+
+	if len(pkID) == 0 {
+		err = errors.Errorf("bad pk: %s", key)
+	}
+	return
 }
 
 func (d *timedDump) update(batch *leveldb.Batch, msg *sarama.ConsumerMessage) {
@@ -193,35 +310,14 @@ func (d *timedDump) update(batch *leveldb.Batch, msg *sarama.ConsumerMessage) {
 
 	switch typ := string(raw["type"]); typ {
 	case `"insert"`, `"update"`, `"bootstrap-insert"`, `"delete"`:
-		var m map[string]json.RawMessage
-		if err := json.Unmarshal(msg.Key, &m); err != nil {
-			panic(err)
-		}
-
-		if len(m) != 3 {
+		database, table, pkID, err := parseKey(msg.Key)
+		if err == errIgnorePK {
 			d.logger.Error(`ignore pk`, zap.Any("key", json.RawMessage(msg.Key)))
 			return
 		}
 
-		var pkID []byte
-		var database, table string
-
-		json.Unmarshal(m["database"], &database)
-		json.Unmarshal(m["table"], &table)
-
-		for k, v := range m {
-			if strings.HasPrefix(k, "pk.") {
-				if bytes.HasPrefix(v, []byte("\"")) {
-					pkID = v[1 : len(v)-1]
-				} else {
-					pkID = v
-				}
-				break
-			}
-		}
-
-		if len(pkID) == 0 {
-			panic(string(msg.Key))
+		if err != nil {
+			panic(err)
 		}
 
 		c := d.DBConfig(string(database))
@@ -234,8 +330,18 @@ func (d *timedDump) update(batch *leveldb.Batch, msg *sarama.ConsumerMessage) {
 		key := buf.Bytes()
 
 		if typ == `"delete"` {
-			batch.Delete([]byte(key))
-			return
+			tc := d.TableConfig(makeDBTable(&bytes.Buffer{}, []byte(database), []byte(table)).String())
+			if !tombstone.IsSoft(tc.DeleteMode) {
+				batch.Delete([]byte(key))
+				return
+			}
+
+			var ts int64
+			_ = json.Unmarshal(raw["ts"], &ts)
+			raw["data"] = tombstone.Mark(raw["data"], ts, msg.Offset)
+			if tc.DeleteMode == tombstone.TTL {
+				batch.Put(makeTombKey([]byte(database), []byte(table), ts, msg.Offset), key)
+			}
 		}
 
 		if d.config.WithoutMeta {
@@ -249,7 +355,7 @@ func (d *timedDump) update(batch *leveldb.Batch, msg *sarama.ConsumerMessage) {
 		}
 
 		r.Data = raw["data"]
-		r.Meta = make(map[string]json.RawMessage, len(m)-1)
+		r.Meta = make(map[string]json.RawMessage, len(raw)-1)
 		for k, data := range raw {
 			if k != `data` {
 				r.Meta[k] = data
@@ -258,15 +364,21 @@ func (d *timedDump) update(batch *leveldb.Batch, msg *sarama.ConsumerMessage) {
 
 		bs, _ := json.Marshal(r)
 		batch.Put(key, bs)
-	case `"bootstrap-start"`, `"bootstrap-complete"`, `"table-create"`:
+	case `"table-create"`, `"table-alter"`, `"table-drop"`, `"database-create"`, `"database-alter"`, `"database-drop"`:
+		d.putSchema(batch, msg)
+	case `"bootstrap-start"`, `"bootstrap-complete"`:
+		d.putBootstrap(batch, raw, msg)
 	default:
 		panic(string(msg.Value))
 	}
 }
 
+// makeDBTable database 为空时 table 已经是 db.table
 func makeDBTable(buf *bytes.Buffer, database, table []byte) *bytes.Buffer {
-	buf.Write(database)
-	buf.Write([]byte("."))
+	if len(database) > 0 {
+		buf.Write(database)
+		buf.Write([]byte("."))
+	}
 	buf.Write(table)
 	return buf
 }
@@ -292,12 +404,51 @@ func makeLogKey(buf *bytes.Buffer, database, table []byte, timestamp string, off
 	return buf
 }
 
+func makeHistKey(buf *bytes.Buffer, database, table, pkID []byte, offset int64) *bytes.Buffer {
+	buf.Write([]byte(HistPrefix))
+	makeDBTable(buf, database, table)
+	buf.Write([]byte("-"))
+	if len(pkID) > 0 {
+		buf.WriteString(fmt.Sprintf("%012s-", pkID))
+		if offset >= 0 {
+			buf.WriteString(fmt.Sprintf("%012d", offset))
+		}
+	}
+	return buf
+}
+
+// clearHist 删除 offset 不大于 lastOffset 的历史记录. hist 和 log 一起写入, lastOffset 是删除的 log 中最大的 offset.
+// 同一主键的记录按 offset 排序, 只比较 key, 遇到较新的记录时跳到下一个主键
+func (d *timedDump) clearHist(batch *leveldb.Batch, table string, lastOffset int64) error {
+	buf := &bytes.Buffer{}
+	makeHistKey(buf, nil, []byte(table), nil, -1)
+
+	iter := d.ldb.NewIterator(util.BytesPrefix(buf.Bytes()), &opt.ReadOptions{DontFillCache: true})
+	defer iter.Release()
+
+	for ok := iter.First(); ok; {
+		key := iter.Key()
+		if keyOffset(key) <= lastOffset {
+			batch.Delete(append([]byte(nil), key...))
+			ok = iter.Next()
+			continue
+		}
+
+		next := append([]byte(nil), key[:bytes.LastIndexByte(key, '-')+1]...)
+		ok = iter.Seek(append(next, 0xff))
+	}
+	return iter.Error()
+}
+
 func (d *timedDump) ClearLog() error {
 	now := time.Now()
 	startBuf, endBuf := &bytes.Buffer{}, &bytes.Buffer{}
 	for table := range d.tables {
 		c := d.TableConfig(table)
-		lastTime := now.Add(-c.Retention).Format(TimestampFmt)
+		if c.Retention <= 0 { // 0 表示一直保留
+			continue
+		}
+		lastTime := now.Add(-c.Retention).UTC().Format(TimestampFmt)
 
 		startBuf.Reset()
 		endBuf.Reset()
@@ -311,8 +462,20 @@ func (d *timedDump) ClearLog() error {
 		batch := new(leveldb.Batch)
 		iter := d.ldb.NewIterator(&util.Range{Start: startTime, Limit: endTime}, &opt.ReadOptions{DontFillCache: true})
 
+		lastOffset := int64(-1)
 		for iter.Next() {
+			offset := keyOffset(iter.Key())
+			if offset > lastOffset {
+				lastOffset = offset
+			}
+
 			batch.Delete(iter.Key())
+			for _, k := range logIndexKeys(table, offset, iter.Value()) {
+				batch.Delete(k)
+			}
+			if k := legacyXidKey(offset, iter.Value()); k != nil {
+				batch.Delete(k)
+			}
 		}
 
 		iter.Release()
@@ -324,31 +487,29 @@ func (d *timedDump) ClearLog() error {
 			continue
 		}
 
+		if err := d.clearHist(batch, table, lastOffset); err != nil {
+			return err
+		}
+
 		d.logger.Warn(`del`, zap.String("table", table), zap.Int(`count`, batch.Len()), zap.String(`timestamp`, lastTime))
 		if err := d.ldb.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
 			return err
 		}
 	}
 
+	// 清除后没有任何 log 的表从 logtables 中删除
 	tables := make([]string, 0, len(d.tables))
-OUTER:
 	for table := range d.tables {
-		c := d.TableConfig(table)
-		lastTime := now.Add(-c.Retention).Format(TimestampFmt)
-
 		startBuf.Reset()
-		endBuf.Reset()
-
-		makeLogKey(startBuf, nil, []byte(table), TimestampMin, 0)
-		makeLogKey(endBuf, nil, []byte(table), lastTime, 0)
-		startTime, endTime := startBuf.Bytes(), endBuf.Bytes()
+		makeLogKey(startBuf, nil, []byte(table), "", 0)
 
-		iter := d.ldb.NewIterator(&util.Range{Start: startTime, Limit: endTime},
-			&opt.ReadOptions{DontFillCache: true})
-		for iter.Next() {
-			iter.Release()
+		iter := d.ldb.NewIterator(util.BytesPrefix(startBuf.Bytes()), &opt.ReadOptions{DontFillCache: true})
+		if iter.First() {
 			tables = append(tables, table)
-			continue OUTER
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
 		}
 	}
 
@@ -366,17 +527,28 @@ OUTER:
 	return d.ldb.Put([]byte(key), bs, &opt.WriteOptions{Sync: true})
 }
 
-func (d *timedDump) QueryLog(opts LogOpt) (interface{}, error) {
-	if len(opts.BeginTime) == 0 {
-		opts.BeginTime = TimestampMin
+func (d *timedDump) QueryLog(opts LogOpt) ([]interface{}, string, error) {
+	var err error
+	opts.BeginTime, opts.EndTime, err = logRange(opts.BeginTime, opts.EndTime, opts.TZ)
+	if err != nil {
+		return nil, "", err
 	}
 
-	if len(opts.EndTime) == 0 {
-		opts.EndTime = TimestampMax
+	f, err := newFilter(opts.Where, opts.Type, opts.Changed, opts.Fields)
+	if err != nil {
+		return nil, "", err
 	}
+	f.redact = d.queryRedact(opts.Database, opts.Table, opts.Reveal)
 
-	if len(opts.BeginTime) != len(TimestampMin) || len(opts.EndTime) != len(TimestampMin) {
-		return nil, errors.New(`bad beginTime/endTime`)
+	if opts.Limit == 0 {
+		opts.Limit = 100
+	}
+
+	if len(opts.Position) > 0 {
+		if opts.BeginTime != TimestampMin || opts.EndTime != TimestampMax {
+			return nil, "", badRequest(`position can not be used with start/end`)
+		}
+		return d.queryLogByPosition(opts, f)
 	}
 
 	startBuf, endBuf := &bytes.Buffer{}, &bytes.Buffer{}
@@ -384,76 +556,88 @@ func (d *timedDump) QueryLog(opts LogOpt) (interface{}, error) {
 	makeLogKey(endBuf, []byte(opts.Database), []byte(opts.Table), opts.EndTime, 0)
 	startTime, endTime := startBuf.Bytes(), endBuf.Bytes()
 
-	type R struct {
-		K string                     `json:"k"`
-		V map[string]json.RawMessage `json:"v"`
-	}
-
-	if opts.Limit == 0 {
-		opts.Limit = 100
+	buf := &bytes.Buffer{}
+	makeLogKey(buf, []byte(opts.Database), []byte(opts.Table), "", 0)
+	cursor, err := decodeCursor(opts.Cursor, buf.Bytes())
+	if err != nil {
+		return nil, "", err
 	}
 
 	var resArr []interface{}
+	var next string
 	iter := d.ldb.NewIterator(&util.Range{
 		Start: startTime,
 		Limit: endTime,
 	}, &opt.ReadOptions{DontFillCache: true})
 
-	next := iter.Next
-	if opts.Reverse {
-		next = iter.Prev
-		iter.Last()
-		iter.Next()
-	}
-
 	skip, take := 0, 0
-	for next() {
+	for ok, step := seek(iter, opts.Reverse, cursor, nil); ok; ok = step() {
+		var r map[string]json.RawMessage
+		_ = json.Unmarshal(iter.Value(), &r)
+		r, matched := f.log(r)
+		if !matched {
+			continue
+		}
+
 		skip++
 		if skip <= opts.Offset {
 			continue
 		}
 
-		take++
-		key, value := string(iter.Key()), json.RawMessage(iter.Value())
-
-		var r map[string]json.RawMessage
-		_ = json.Unmarshal(value, &r)
-		resArr = append(resArr, R{key, r})
-
 		if take >= opts.Limit {
+			next = encodeCursor(resArr[len(resArr)-1].(record).K)
 			break
 		}
+
+		take++
+		resArr = append(resArr, record{string(iter.Key()), r})
 	}
 
 	iter.Release()
 
-	return resArr, iter.Error()
+	return resArr, next, iter.Error()
 }
 
-func (d *timedDump) QueryRow(opts RowOpt) ([]interface{}, error) {
-	type R struct {
-		K string                     `json:"k"`
-		V map[string]json.RawMessage `json:"v"`
-	}
-
+func (d *timedDump) QueryRow(opts RowOpt) ([]interface{}, string, error) {
 	if opts.Limit == 0 {
 		opts.Limit = 1
 	}
 
+	if len(opts.At) > 0 {
+		if len(opts.PkID) == 0 {
+			return nil, "", badRequest(`at requires pk`)
+		}
+		resArr, err := d.rowAsOf(opts)
+		return resArr, "", err
+	}
+
+	f, err := newFilter(opts.Where, "", "", opts.Fields)
+	if err != nil {
+		return nil, "", err
+	}
+	f.redact = d.queryRedact(opts.Database, opts.Table, opts.Reveal)
+
 	buf := &bytes.Buffer{}
-	if len(opts.PkID) > 0 {
+	if len(opts.PkID) > 0 && len(opts.Cursor) == 0 {
 		if opts.Limit == 1 {
 			makeRowKey(buf, []byte(opts.Database), []byte(opts.Table), []byte(opts.PkID))
 			key := buf.Bytes()
 
 			v, err := d.ldb.Get(key, &opt.ReadOptions{DontFillCache: true})
 			if err != nil {
-				return nil, err
+				return nil, "", err
+			}
+
+			if !opts.IncludeDeleted && isDeletedRow(v, d.config.WithoutMeta) {
+				return []interface{}{}, "", nil
 			}
 
 			var r map[string]json.RawMessage
 			_ = json.Unmarshal(v, &r)
-			return []interface{}{R{string(key), r}}, nil
+			if r, ok := f.row(r, d.config.WithoutMeta); ok {
+				return []interface{}{record{string(key), r}}, "", nil
+			}
+			return []interface{}{}, "", nil
 		}
 	}
 
@@ -461,53 +645,128 @@ func (d *timedDump) QueryRow(opts RowOpt) ([]interface{}, error) {
 	makeRowKey(buf, []byte(opts.Database), []byte(opts.Table), nil)
 	key := buf.Bytes()
 
+	cursor, err := decodeCursor(opts.Cursor, key)
+	if err != nil {
+		return nil, "", err
+	}
+
 	iter := d.ldb.NewIterator(util.BytesPrefix(key), nil)
 
+	var start []byte
 	if len(opts.PkID) > 0 {
-		buf.Reset()
+		buf := &bytes.Buffer{}
 		makeRowKey(buf, []byte(opts.Database), []byte(opts.Table), []byte(opts.PkID))
-		iter.Seek(buf.Bytes())
-		iter.Prev()
+		start = buf.Bytes()
 	}
 
 	skip, take := 0, 0
 	var resArr []interface{}
-	for iter.Next() {
+	var next string
+	for ok, step := seek(iter, opts.Reverse, cursor, start); ok; ok = step() {
+		if !opts.IncludeDeleted && isDeletedRow(iter.Value(), d.config.WithoutMeta) {
+			continue
+		}
+
+		var r map[string]json.RawMessage
+		_ = json.Unmarshal(iter.Value(), &r)
+		r, matched := f.row(r, d.config.WithoutMeta)
+		if !matched {
+			continue
+		}
+
 		skip++
 		if skip <= opts.Offset {
 			continue
 		}
 
+		if take >= opts.Limit {
+			next = encodeCursor(resArr[len(resArr)-1].(record).K)
+			break
+		}
+
 		take++
-		key, value := string(iter.Key()), json.RawMessage(iter.Value())
+		resArr = append(resArr, record{string(iter.Key()), r})
+	}
 
-		var r map[string]json.RawMessage
-		_ = json.Unmarshal(value, &r)
-		resArr = append(resArr, R{key, r})
+	iter.Release()
+	return resArr, next, iter.Error()
+}
+
+func (d *timedDump) QueryHistory(opts HistOpt) ([]interface{}, string, error) {
+	if opts.Limit == 0 {
+		opts.Limit = 100
+	}
+
+	f := &filter{redact: d.queryRedact(opts.Database, opts.Table, opts.Reveal)}
+
+	buf := &bytes.Buffer{}
+	makeHistKey(buf, []byte(opts.Database), []byte(opts.Table), []byte(opts.PkID), -1)
+	cursor, err := decodeCursor(opts.Cursor, buf.Bytes())
+	if err != nil {
+		return nil, "", err
+	}
+
+	iter := d.ldb.NewIterator(util.BytesPrefix(buf.Bytes()), &opt.ReadOptions{DontFillCache: true})
+
+	skip, take := 0, 0
+	var resArr []interface{}
+	var next string
+	for ok, step := seek(iter, opts.Reverse, cursor, nil); ok; ok = step() {
+		skip++
+		if skip <= opts.Offset {
+			continue
+		}
 
 		if take >= opts.Limit {
+			next = encodeCursor(resArr[len(resArr)-1].(record).K)
 			break
 		}
+
+		take++
+		key, value := string(iter.Key()), json.RawMessage(iter.Value())
+
+		var r map[string]json.RawMessage
+		_ = json.Unmarshal(value, &r)
+		r, _ = f.log(r)
+		resArr = append(resArr, record{key, r})
 	}
 
 	iter.Release()
-	return resArr, iter.Error()
+	return resArr, next, iter.Error()
 }
 
-func (d *timedDump) ResetOffset(offset int) error {
-	key := []byte(KeyPrefix + "offset")
-	if offset <= 0 {
-		return d.ldb.Delete(key, &opt.WriteOptions{Sync: true})
-	}
-
+// ResetOffset 保存新的 offset 并记录审计, 由调用方停止和重启消费
+func (d *timedDump) ResetOffset(r *offsets.Rewind) error {
+	// rewind 到 0 时保存 -1, 重启后从 0 开始而不是按 StartFrom
+	batch := new(leveldb.Batch)
 	var buf = make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, uint64(offset))
-	if err := d.ldb.Put(key, buf, &opt.WriteOptions{Sync: true}); err != nil {
+	binary.BigEndian.PutUint64(buf, uint64(r.To-1))
+	batch.Put([]byte(KeyPrefix+"offset"), buf)
+
+	bs, _ := json.Marshal(r)
+	batch.Put([]byte(AuditPrefix+"offset-"+time.Now().Format(TimestampFmt+".000000000")), bs)
+	if err := d.ldb.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
 		return err
 	}
 
-	d.logger.Warn(`exit normally`)
-
-	os.Exit(0)
+	d.logger.Warn(`reset offset`, zap.Int64("from", r.From), zap.Int64("to", r.To),
+		zap.String("mode", r.Mode), zap.String("remote", r.Remote))
+	if d.feed != nil {
+		d.feed.rewind()
+	}
 	return nil
 }
+
+// Pending 返回还未写入的缓存条数
+func (d *timedDump) Pending() int {
+	return len(d.cache)
+}
+
+// Discard 丢弃还未写入的缓存
+func (d *timedDump) Discard() {
+	if len(d.cache) > 0 {
+		d.logger.Warn(`discard`, zap.Int(`count`, len(d.cache)))
+	}
+	d.cache = make([]*sarama.ConsumerMessage, 0, d.size)
+	d.batcher.Reset()
+}
@@ -1,21 +1,28 @@
 package mw2ldb
 
 import (
-	"github.com/Shopify/sarama"
 	"testing"
-	"time"
 )
 
+// TestTimedDump 缓存满 size 条时写入, 剩下的等 Dump(nil) 写入
 func TestTimedDump(t *testing.T) {
-	var dump Dump = &timedDump{
-		size:3,
-	}
-	dump.Initialize()
+	d := newTestDump(t)
+	d.size = 3
+	d.batcher.Size = 3
 
-	dump.Dump(&sarama.ConsumerMessage{})
-	dump.Dump(&sarama.ConsumerMessage{})
-	dump.Dump(&sarama.ConsumerMessage{})
-	dump.Dump(&sarama.ConsumerMessage{})
+	for i := 1; i <= 4; i++ {
+		if err := d.Dump(testMsg(int64(i), i, "insert", 1000, `{"id":1}`, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last, _ := d.LastOffset(); last != 3 || d.Pending() != 1 {
+		t.Errorf("expect offset 3 saved and 1 cached, got %d, %d", last, d.Pending())
+	}
 
-	time.Sleep(time.Second*4)
-}
\ No newline at end of file
+	if err := d.Dump(nil); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := d.LastOffset(); last != 4 || d.Pending() != 0 {
+		t.Errorf("expect offset 4 saved, got %d, %d", last, d.Pending())
+	}
+}
@@ -0,0 +1,114 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/helloshiki/maxwell-output/pkg/tombstone"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.uber.org/zap"
+)
+
+// tombKeySuffix 是 tomb: 键中表名之后的部分: -20190912000052-000000064734
+const tombKeySuffix = 1 + len(TimestampFmt) + 1 + 12
+
+// makeTombKey 按删除时间索引 tombstone-ttl 的软删除行, 值是 row: 键
+func makeTombKey(database, table []byte, ts, offset int64) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(TombPrefix)
+	makeDBTable(buf, database, table)
+	buf.WriteString(fmt.Sprintf("-%s-%012d", time.Unix(ts, 0).UTC().Format(TimestampFmt), offset))
+	return buf.Bytes()
+}
+
+// isDeletedRow 检查 row: 的值是否是软删除的行
+func isDeletedRow(v []byte, withoutMeta bool) bool {
+	if !bytes.Contains(v, []byte(`"`+tombstone.Deleted+`":true`)) {
+		return false
+	}
+
+	var row map[string]json.RawMessage
+	if withoutMeta {
+		_ = json.Unmarshal(v, &row)
+	} else {
+		var r struct {
+			Data map[string]json.RawMessage `json:"data"`
+		}
+		_ = json.Unmarshal(v, &r)
+		row = r.Data
+	}
+	return tombstone.IsDeleted(row)
+}
+
+// PurgeDeleted 清除超过 TombstoneTTL 的软删除行. 行在删除后又被插入时只清除 tomb: 键
+func (d *timedDump) PurgeDeleted() error {
+	now := time.Now()
+	batch := new(leveldb.Batch)
+	purged := 0
+
+	iter := d.ldb.NewIterator(util.BytesPrefix([]byte(TombPrefix)), &opt.ReadOptions{DontFillCache: true})
+	for iter.Next() {
+		k := iter.Key()
+		if len(k) < len(TombPrefix)+tombKeySuffix {
+			batch.Delete(k)
+			continue
+		}
+
+		table := string(k[len(TombPrefix) : len(k)-tombKeySuffix])
+		ttl := d.TableConfig(table).tombstoneTTL()
+		if ttl <= 0 {
+			continue
+		}
+
+		ts := k[len(k)-tombKeySuffix+1 : len(k)-13]
+		if string(ts) >= now.Add(-ttl).UTC().Format(TimestampFmt) {
+			continue
+		}
+
+		v, err := d.ldb.Get(iter.Value(), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			iter.Release()
+			return err
+		}
+
+		if err == nil && isDeletedRow(v, d.config.WithoutMeta) && deletedOffset(v, d.config.WithoutMeta) == keyOffset(k) {
+			batch.Delete(iter.Value())
+			purged++
+		}
+		batch.Delete(k)
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	d.logger.Warn(`purge deleted`, zap.Int(`count`, purged))
+	return d.ldb.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// deletedOffset 返回软删除行的 _deleted_offset
+func deletedOffset(v []byte, withoutMeta bool) int64 {
+	var r struct {
+		Offset int64 `json:"_deleted_offset"`
+	}
+	if withoutMeta {
+		_ = json.Unmarshal(v, &r)
+		return r.Offset
+	}
+
+	var row struct {
+		Data json.RawMessage `json:"data"`
+	}
+	_ = json.Unmarshal(v, &row)
+	_ = json.Unmarshal(row.Data, &r)
+	return r.Offset
+}
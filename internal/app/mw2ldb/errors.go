@@ -0,0 +1,16 @@
+package mw2ldb
+
+import "fmt"
+
+// badRequestError 是调用方参数错误, /v1 返回 400
+type badRequestError struct {
+	msg string
+}
+
+func (e *badRequestError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &badRequestError{msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,246 @@
+package mw2ldb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// feedEvent 是已经写入 log: 的一条变更
+type feedEvent struct {
+	key    string // log: 键
+	table  string // db.table
+	offset int64
+	value  []byte
+	rewind bool // kafka offset 被重置, 之后的 offset 可能变小
+}
+
+type subscriber struct {
+	table  string
+	events chan *feedEvent
+	lagged bool // 来不及消费被踢掉
+}
+
+// broker 把 flush 落盘后的变更推给订阅者, 推送不阻塞 flush
+type broker struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[*subscriber]struct{})}
+}
+
+func (b *broker) subscribe(table string) *subscriber {
+	s := &subscriber{table: table, events: make(chan *feedEvent, 1024)}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *broker) unsubscribe(s *subscriber) {
+	b.mu.Lock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+	b.mu.Unlock()
+}
+
+func (b *broker) publish(events []*feedEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		for _, e := range events {
+			if e.table != s.table {
+				continue
+			}
+
+			if !b.send(s, e) {
+				break
+			}
+		}
+	}
+}
+
+// rewind 在 offset 重置后通知所有订阅者, 重新消费的变更不会被当作重复丢掉
+func (b *broker) rewind() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e := &feedEvent{rewind: true}
+	for s := range b.subs {
+		b.send(s, e)
+	}
+}
+
+// send 不阻塞, 订阅者的队列满时踢掉它并返回 false
+func (b *broker) send(s *subscriber, e *feedEvent) bool {
+	select {
+	case s.events <- e:
+		return true
+	default:
+		s.lagged = true
+		delete(b.subs, s)
+		close(s.events)
+		return false
+	}
+}
+
+// SubscribeOpt 订阅一张表的变更, 可以从 kafka offset 或者 log: 键之后开始回放
+type SubscribeOpt struct {
+	Database string
+	Table    string
+	Offset   int64  // optional, -1 表示只推送新的变更
+	Cursor   string // optional, log: 键, 优先于 Offset
+	Type     string // optional, insert,update
+	Changed  string // optional, columns in old
+	Where    string // optional
+	Fields   string // optional
+	Reveal   bool   // optional, 解密 encrypt 的列
+}
+
+var errLagged = errors.New(`subscriber lagged`)
+
+// Subscribe 以 server-sent events 写入 w, 直到写失败或者订阅者落后太多
+func (d *timedDump) Subscribe(opts SubscribeOpt, w *bufio.Writer) error {
+	send := func(r *record, offset int64) error {
+		var typ string
+		_ = json.Unmarshal(r.V["type"], &typ)
+		bs, _ := json.Marshal(struct {
+			K      string                     `json:"k"`
+			Offset int64                      `json:"offset"`
+			V      map[string]json.RawMessage `json:"v"`
+		}{r.K, offset, r.V})
+
+		_, _ = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", encodeCursor(r.K), typ, bs)
+		return w.Flush()
+	}
+
+	// 保活, 同时发现断开的连接
+	ping := func() error {
+		_, _ = w.WriteString(": ping " + strconv.FormatInt(time.Now().Unix(), 10) + "\n\n")
+		return w.Flush()
+	}
+
+	err := d.Watch(context.Background(), opts, send, ping)
+	if err == errLagged {
+		_, _ = w.WriteString("event: error\ndata: lagged, resume with the last id\n\n")
+		_ = w.Flush()
+	}
+	return err
+}
+
+// Watch 先从 log: 回放积压的变更, 再推送新的变更, 直到 ctx 结束, send 或 idle 返回错误, 或者订阅者落后太多 (errLagged).
+// 没有变更时每 15 秒调用一次 idle
+func (d *timedDump) Watch(ctx context.Context, opts SubscribeOpt, send func(r *record, offset int64) error, idle func() error) error {
+	if d.feed == nil {
+		return errors.New(`feed is not available`)
+	}
+
+	f, err := newFilter(opts.Where, opts.Type, opts.Changed, opts.Fields)
+	if err != nil {
+		return err
+	}
+	f.redact = d.queryRedact(opts.Database, opts.Table, opts.Reveal)
+
+	buf := &bytes.Buffer{}
+	makeDBTable(buf, []byte(opts.Database), []byte(opts.Table))
+	table := buf.String()
+
+	buf.Reset()
+	makeLogKey(buf, []byte(opts.Database), []byte(opts.Table), "", 0)
+	prefix := append([]byte(nil), buf.Bytes()...)
+
+	cursor, err := decodeCursor(opts.Cursor, prefix)
+	if err != nil {
+		return err
+	}
+
+	// 先订阅再取快照, 回放和推送之间不会漏掉变更, 重复的按 offset 去掉
+	s := d.feed.subscribe(table)
+	defer d.feed.unsubscribe(s)
+
+	emit := func(key string, offset int64, value []byte) error {
+		var v map[string]json.RawMessage
+		_ = json.Unmarshal(value, &v)
+		v, ok := f.log(v)
+		if !ok {
+			return nil
+		}
+		return send(&record{K: key, V: v}, offset)
+	}
+
+	last := int64(-1)
+	if len(cursor) > 0 || opts.Offset >= 0 {
+		snap, err := d.ldb.GetSnapshot()
+		if err != nil {
+			return err
+		}
+
+		iter := snap.NewIterator(util.BytesPrefix(prefix), &opt.ReadOptions{DontFillCache: true})
+		for ok, step := seek(iter, false, cursor, nil); ok; ok = step() {
+			offset := keyOffset(iter.Key())
+			if len(cursor) == 0 && offset <= opts.Offset {
+				continue
+			}
+
+			if err := emit(string(iter.Key()), offset, iter.Value()); err != nil {
+				iter.Release()
+				snap.Release()
+				return err
+			}
+			if offset > last {
+				last = offset
+			}
+		}
+
+		iter.Release()
+		snap.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				return errLagged
+			}
+
+			if e.rewind {
+				last = -1
+				continue
+			}
+
+			if e.offset <= last {
+				continue
+			}
+
+			if err := emit(e.key, e.offset, e.value); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := idle(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
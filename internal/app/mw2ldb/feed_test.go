@@ -0,0 +1,59 @@
+package mw2ldb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+)
+
+func TestWatchRewind(t *testing.T) {
+	d := newTestDump(t)
+	d.feed = newBroker()
+
+	dump := func(from, to int64) {
+		for i := from; i <= to; i++ {
+			if err := d.Dump(testMsg(i, int(i), "insert", 1000, `{"id":1}`, "")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Dump(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dump(1, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := make(chan int64, 10)
+	go func() {
+		_ = d.Watch(ctx, SubscribeOpt{Database: "hsn", Table: "user_info", Offset: 0},
+			func(r *record, offset int64) error {
+				got <- offset
+				return nil
+			}, func() error { return nil })
+	}()
+
+	expect := func(want ...int64) {
+		for _, w := range want {
+			select {
+			case offset := <-got:
+				if offset != w {
+					t.Fatalf("got offset %d, want %d", offset, w)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timeout waiting for offset %d", w)
+			}
+		}
+	}
+	expect(1, 2)
+
+	// rewind 后重新消费的变更也要推送
+	if err := d.ResetOffset(&offsets.Rewind{Mode: offsets.ModeOffset, From: 3, To: 1}); err != nil {
+		t.Fatal(err)
+	}
+	dump(1, 3)
+	expect(1, 2, 3)
+}
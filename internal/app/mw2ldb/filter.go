@@ -0,0 +1,235 @@
+package mw2ldb
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// cond 是 where 中的一个条件, 如 partner_level>=2
+type cond struct {
+	field string
+	op    string // : = != > >= < <=
+	value string
+}
+
+// filter 在 QueryRow/QueryLog 的迭代中过滤和投影, 零值表示不过滤
+type filter struct {
+	where   []cond
+	types   map[string]bool
+	changed []string
+	fields  []string
+	redact  redactFunc // 先脱敏再过滤, 避免用 where 猜测原值
+}
+
+// newFilter 解析 where=is_active:yes,partner_level>=2, type=insert,update, changed=a,b 以及 fields=a,b
+func newFilter(where, types, changed, fields string) (*filter, error) {
+	f := &filter{changed: splitList(changed), fields: splitList(fields)}
+
+	for _, s := range splitList(where) {
+		i := strings.IndexAny(s, ":=!<>")
+		if i <= 0 {
+			return nil, badRequest(`bad where: %s`, s)
+		}
+
+		c := cond{field: s[:i], op: s[i : i+1]}
+		if i+1 < len(s) && s[i+1] == '=' && c.op != ":" && c.op != "=" {
+			c.op += "="
+		}
+		if c.op == "!" {
+			return nil, badRequest(`bad where: %s`, s)
+		}
+
+		c.value = s[i+len(c.op):]
+		f.where = append(f.where, c)
+	}
+
+	if ts := splitList(types); len(ts) > 0 {
+		f.types = make(map[string]bool, len(ts))
+		for _, t := range ts {
+			f.types[t] = true
+		}
+	}
+
+	return f, nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// match 判断 data 是否满足所有 where 条件, 不存在的列当作 null
+func (f *filter) match(data map[string]json.RawMessage) bool {
+	for _, c := range f.where {
+		if !c.match(data[c.field]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *cond) match(v json.RawMessage) bool {
+	if len(v) == 0 {
+		v = json.RawMessage("null")
+	}
+
+	var cmp int
+	switch {
+	case v[0] == '"':
+		var s string
+		_ = json.Unmarshal(v, &s)
+		cmp = strings.Compare(s, c.value)
+	case v[0] == 't' || v[0] == 'f':
+		b, err := parseBool(c.value)
+		if err != nil {
+			return false
+		}
+		if (v[0] == 't') == b {
+			cmp = 0
+		} else {
+			cmp = 1
+		}
+		if c.op != ":" && c.op != "=" && c.op != "!=" {
+			return false
+		}
+	case string(v) == "null":
+		cmp = strings.Compare("null", c.value)
+		if c.op != ":" && c.op != "=" && c.op != "!=" {
+			return false
+		}
+	default: // 数字
+		x, err1 := strconv.ParseFloat(string(v), 64)
+		y, err2 := strconv.ParseFloat(c.value, 64)
+		if err1 != nil || err2 != nil {
+			cmp = strings.Compare(string(v), c.value)
+			break
+		}
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	}
+
+	switch c.op {
+	case ":", "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	default: // <=
+		return cmp <= 0
+	}
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+// project 只保留 fields 中的列
+func (f *filter) project(data map[string]json.RawMessage) map[string]json.RawMessage {
+	if len(f.fields) == 0 || data == nil {
+		return data
+	}
+
+	res := make(map[string]json.RawMessage, len(f.fields))
+	for _, k := range f.fields {
+		if v, ok := data[k]; ok {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// row 过滤并投影 row: 的值, v 在 WithoutMeta 时就是 data, 否则是 {data, meta}
+func (f *filter) row(v map[string]json.RawMessage, withoutMeta bool) (map[string]json.RawMessage, bool) {
+	if withoutMeta {
+		f.redact.apply(v)
+		if !f.match(v) {
+			return nil, false
+		}
+		return f.project(v), true
+	}
+
+	if len(f.where) == 0 && len(f.fields) == 0 && f.redact == nil {
+		return v, true
+	}
+
+	var data map[string]json.RawMessage
+	_ = json.Unmarshal(v["data"], &data)
+	f.redact.apply(data)
+	if !f.match(data) {
+		return nil, false
+	}
+
+	if len(f.fields) > 0 || f.redact != nil {
+		v["data"], _ = json.Marshal(f.project(data))
+	}
+	return v, true
+}
+
+// log 过滤并投影 log: 的值, 即 maxwell 的原始消息
+func (f *filter) log(v map[string]json.RawMessage) (map[string]json.RawMessage, bool) {
+	if len(f.types) > 0 {
+		var typ string
+		_ = json.Unmarshal(v["type"], &typ)
+		if !f.types[typ] {
+			return nil, false
+		}
+	}
+
+	if len(f.where) == 0 && len(f.changed) == 0 && len(f.fields) == 0 && f.redact == nil {
+		return v, true
+	}
+
+	var data, old map[string]json.RawMessage
+	_ = json.Unmarshal(v["data"], &data)
+	_ = json.Unmarshal(v["old"], &old)
+	f.redact.apply(data)
+	f.redact.apply(old)
+
+	if len(f.changed) > 0 {
+		changed := false
+		for _, k := range f.changed {
+			if _, ok := old[k]; ok {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return nil, false
+		}
+	}
+
+	if !f.match(data) {
+		return nil, false
+	}
+
+	if len(f.fields) > 0 || f.redact != nil {
+		if data != nil {
+			v["data"], _ = json.Marshal(f.project(data))
+		}
+		if old != nil {
+			v["old"], _ = json.Marshal(f.project(old))
+		}
+	}
+	return v, true
+}
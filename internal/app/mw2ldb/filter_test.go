@@ -0,0 +1,61 @@
+package mw2ldb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	data := map[string]json.RawMessage{
+		"id":            json.RawMessage(`177`),
+		"is_active":     json.RawMessage(`"yes"`),
+		"partner_level": json.RawMessage(`2`),
+		"deleted":       json.RawMessage(`false`),
+		"note":          json.RawMessage(`null`),
+		"created_at":    json.RawMessage(`"2019-09-12 00:00:52"`),
+	}
+
+	cases := []struct {
+		where  string
+		expect bool
+	}{
+		{"", true},
+		{"is_active:yes,partner_level>=2", true},
+		{"is_active:no", false},
+		{"partner_level>2", false},
+		{"partner_level<10", true},
+		{"partner_level!=2", false},
+		{"deleted:no", true},
+		{"deleted=true", false},
+		{"note:null", true},
+		{"missing:null", true},
+		{"created_at>=2019-09-12", true},
+		{"created_at<2019-09-12", false},
+	}
+
+	for _, c := range cases {
+		f, err := newFilter(c.where, "", "", "")
+		if err != nil {
+			t.Fatal(c.where, err)
+		}
+		if got := f.match(data); got != c.expect {
+			t.Errorf("%s: got %v", c.where, got)
+		}
+	}
+
+	for _, where := range []string{"a", ":1", "a!1"} {
+		if _, err := newFilter(where, "", "", ""); err == nil {
+			t.Errorf("%s: expect error", where)
+		}
+	}
+
+	f, _ := newFilter("", "update", "partner_level", "id")
+	v := map[string]json.RawMessage{
+		"type": json.RawMessage(`"update"`),
+		"data": json.RawMessage(`{"id":177,"partner_level":2}`),
+		"old":  json.RawMessage(`{"partner_level":1}`),
+	}
+	if v, ok := f.log(v); !ok || string(v["data"]) != `{"id":177}` || string(v["old"]) != `{}` {
+		t.Errorf("log: %v %s %s", ok, v["data"], v["old"])
+	}
+}
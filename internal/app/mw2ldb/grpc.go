@@ -0,0 +1,350 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/helloshiki/maxwell-output/pkg/auth"
+	"github.com/helloshiki/maxwell-output/pkg/ldbpb"
+	"github.com/syndtr/goleveldb/leveldb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpc 接口, 见 pkg/ldbpb/ldb.proto. 认证和 http 相同, token 放在 authorization: Bearer <token> 元数据中
+
+type clientKey struct{}
+
+// ldbService 实现 ldbpb.LdbServer, 和 /v1 共用 Dump 的查询
+type ldbService struct {
+	ldbpb.UnimplementedLdbServer
+	c *Server
+}
+
+func (c *Server) startGrpcServer() {
+	addr := c.GRPCAddr
+	if len(addr) == 0 {
+		return
+	}
+
+	logger := c.logger.With(zap.String("sub", "grpc"))
+	cfg, err := auth.TLSConfig(c.Auth)
+	if err != nil {
+		logger.Fatal(`listen fail`, zap.String("e", err.Error()))
+		os.Exit(1)
+	}
+
+	var opts []grpc.ServerOption
+	if cfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+
+	a := auth.New(c.Auth)
+	opts = append(opts,
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := grpcAuthenticate(a, ctx)
+			if err != nil {
+				return nil, grpcError(err)
+			}
+
+			resp, err := handler(ctx, req)
+			if err != nil {
+				logger.Info(info.FullMethod, zap.String("e", err.Error()))
+				return nil, grpcError(err)
+			}
+			return resp, nil
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := grpcAuthenticate(a, ss.Context())
+			if err != nil {
+				return grpcError(err)
+			}
+
+			if err := handler(srv, &authedStream{ServerStream: ss, ctx: ctx}); err != nil {
+				logger.Info(info.FullMethod, zap.String("e", err.Error()))
+				return grpcError(err)
+			}
+			return nil
+		}),
+	)
+
+	s := grpc.NewServer(opts...)
+	ldbpb.RegisterLdbServer(s, &ldbService{c: c})
+
+	logger.Sugar().Infof(`listen %s`, addr)
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		logger.Fatal(`listen fail`, zap.String("e", err.Error()))
+		os.Exit(1)
+	}
+
+	if err := s.Serve(ln); err != nil {
+		logger.Fatal(`listen fail`, zap.String("e", err.Error()))
+		os.Exit(1)
+	}
+}
+
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcAuthenticate 依次尝试客户端证书和 authorization 元数据, 认证通过的 Client 保存在 ctx 中
+func grpcAuthenticate(a *auth.Authenticator, ctx context.Context) (context.Context, error) {
+	if a == nil {
+		return ctx, nil
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if client := a.AuthenticateCert(&info.State); client != nil {
+				return context.WithValue(ctx, clientKey{}, client), nil
+			}
+		}
+	}
+
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			token = auth.BearerToken(v[0])
+		}
+	}
+
+	client, err := a.AuthenticateToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, clientKey{}, client), nil
+}
+
+// grpcClient 检查 ctx 中的 Client 是否可以访问 database.table, 没有配置认证时返回 nil
+func grpcClient(ctx context.Context, database, table string) (*auth.Client, error) {
+	if len(database) == 0 || len(table) == 0 {
+		return nil, badRequest("bad database/table")
+	}
+
+	client, _ := ctx.Value(clientKey{}).(*auth.Client)
+	return client, allowTable(client, database, table)
+}
+
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch err.(type) {
+	case *badRequestError:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	switch err {
+	case leveldb.ErrNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case auth.ErrUnauthorized:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case auth.ErrForbidden:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errLagged:
+		return status.Error(codes.Aborted, "lagged, resume with the key of the last change as cursor")
+	case context.Canceled, context.DeadlineExceeded:
+		return status.FromContextError(err).Err()
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+func grpcLimit(limit int32) (int, error) {
+	if limit < 0 {
+		return 0, badRequest("bad limit")
+	}
+
+	if limit == 0 {
+		return 100, nil
+	}
+	return int(limit), nil
+}
+
+func (s *ldbService) GetRow(ctx context.Context, req *ldbpb.GetRowRequest) (*ldbpb.Row, error) {
+	client, err := grpcClient(ctx, req.Database, req.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Pk) == 0 {
+		return nil, badRequest("bad pk")
+	}
+
+	opt := RowOpt{Database: req.Database, Table: req.Table, PkID: req.Pk, Limit: 1, Fields: req.Fields, At: req.At, TZ: req.Tz}
+	opt.Reveal, opt.IncludeDeleted = client != nil && client.Decrypt, req.IncludeDeleted
+
+	resArr, _, err := s.c.dumper.QueryRow(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resArr) == 0 {
+		return nil, leveldb.ErrNotFound
+	}
+	return s.c.pbRow(resArr[0].(record)), nil
+}
+
+func (s *ldbService) ScanRows(ctx context.Context, req *ldbpb.ScanRowsRequest) (*ldbpb.ScanRowsResponse, error) {
+	client, err := grpcClient(ctx, req.Database, req.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := RowOpt{Database: req.Database, Table: req.Table, Where: req.Where, Fields: req.Fields, Cursor: req.Cursor, Reverse: req.Reverse}
+	opt.Reveal, opt.IncludeDeleted = client != nil && client.Decrypt, req.IncludeDeleted
+	if opt.Limit, err = grpcLimit(req.Limit); err != nil {
+		return nil, err
+	}
+
+	resArr, next, err := s.c.dumper.QueryRow(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ldbpb.ScanRowsResponse{Rows: make([]*ldbpb.Row, 0, len(resArr)), NextCursor: next}
+	for _, r := range resArr {
+		resp.Rows = append(resp.Rows, s.c.pbRow(r.(record)))
+	}
+	return resp, nil
+}
+
+func (s *ldbService) ScanLog(ctx context.Context, req *ldbpb.ScanLogRequest) (*ldbpb.ScanLogResponse, error) {
+	client, err := grpcClient(ctx, req.Database, req.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := LogOpt{Database: req.Database, Table: req.Table, Where: req.Where, Type: req.Type, Changed: req.Changed,
+		Fields: req.Fields, Cursor: req.Cursor, Reverse: req.Reverse, BeginTime: req.Start, EndTime: req.End, TZ: req.Tz, Position: req.Position}
+	opt.Reveal = client != nil && client.Decrypt
+	if opt.Limit, err = grpcLimit(req.Limit); err != nil {
+		return nil, err
+	}
+
+	resArr, next, err := s.c.dumper.QueryLog(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ldbpb.ScanLogResponse{Changes: make([]*ldbpb.Change, 0, len(resArr)), NextCursor: next}
+	for _, r := range resArr {
+		resp.Changes = append(resp.Changes, pbChange(r.(record)))
+	}
+	return resp, nil
+}
+
+func (s *ldbService) WatchChanges(req *ldbpb.WatchChangesRequest, stream ldbpb.Ldb_WatchChangesServer) error {
+	ctx := stream.Context()
+	client, err := grpcClient(ctx, req.Database, req.Table)
+	if err != nil {
+		return err
+	}
+
+	opt := SubscribeOpt{Database: req.Database, Table: req.Table, Offset: -1, Cursor: req.Cursor,
+		Type: req.Type, Changed: req.Changed, Where: req.Where, Fields: req.Fields}
+	opt.Reveal = client != nil && client.Decrypt
+	if req.Offset != nil {
+		if *req.Offset < 0 {
+			return badRequest("bad offset")
+		}
+		opt.Offset = *req.Offset
+	}
+
+	send := func(r *record, offset int64) error {
+		e := pbChange(*r)
+		e.Offset = offset
+		return stream.Send(e)
+	}
+	return s.c.dumper.Watch(ctx, opt, send, ctx.Err)
+}
+
+func (c *Server) pbRow(r record) *ldbpb.Row {
+	if c.WithoutMeta {
+		return &ldbpb.Row{Key: r.K, Data: pbValues(r.V)}
+	}
+
+	return &ldbpb.Row{Key: r.K, Data: pbObject(r.V["data"]), Meta: pbObject(r.V["meta"])}
+}
+
+func pbChange(r record) *ldbpb.Change {
+	e := &ldbpb.Change{Key: r.K, Offset: keyOffset([]byte(r.K))}
+	_ = json.Unmarshal(r.V["database"], &e.Database)
+	_ = json.Unmarshal(r.V["table"], &e.Table)
+	_ = json.Unmarshal(r.V["type"], &e.Type)
+	_ = json.Unmarshal(r.V["ts"], &e.Ts)
+	_ = json.Unmarshal(r.V["xid"], &e.Xid)
+	_ = json.Unmarshal(r.V["commit"], &e.Commit)
+	_ = json.Unmarshal(r.V["position"], &e.Position)
+	e.Data, e.Old = pbObject(r.V["data"]), pbObject(r.V["old"])
+	return e
+}
+
+func pbObject(raw json.RawMessage) map[string]*ldbpb.Value {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var m map[string]json.RawMessage
+	_ = json.Unmarshal(raw, &m)
+	return pbValues(m)
+}
+
+func pbValues(m map[string]json.RawMessage) map[string]*ldbpb.Value {
+	if m == nil {
+		return nil
+	}
+
+	values := make(map[string]*ldbpb.Value, len(m))
+	for k, v := range m {
+		values[k] = pbValue(v)
+	}
+	return values
+}
+
+// pbValue 把 json 值转换成 ldbpb.Value, 数字在 int64/double 中放不下时保留原文
+func pbValue(raw json.RawMessage) *ldbpb.Value {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return &ldbpb.Value{Kind: &ldbpb.Value_NullValue{NullValue: true}}
+	}
+
+	switch c := raw[0]; {
+	case c == 'n':
+		return &ldbpb.Value{Kind: &ldbpb.Value_NullValue{NullValue: true}}
+	case c == 't' || c == 'f':
+		return &ldbpb.Value{Kind: &ldbpb.Value_BoolValue{BoolValue: c == 't'}}
+	case c == '"':
+		var s string
+		_ = json.Unmarshal(raw, &s)
+		return &ldbpb.Value{Kind: &ldbpb.Value_StringValue{StringValue: s}}
+	case c == '{' || c == '[':
+		return &ldbpb.Value{Kind: &ldbpb.Value_JsonValue{JsonValue: append([]byte(nil), raw...)}}
+	}
+
+	s := string(raw)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return &ldbpb.Value{Kind: &ldbpb.Value_IntValue{IntValue: n}}
+	}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil && strconv.FormatFloat(f, 'g', -1, 64) == s {
+		return &ldbpb.Value{Kind: &ldbpb.Value_DoubleValue{DoubleValue: f}}
+	}
+	return &ldbpb.Value{Kind: &ldbpb.Value_StringValue{StringValue: s}}
+}
@@ -0,0 +1,31 @@
+package mw2ldb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/helloshiki/maxwell-output/pkg/ldbpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPbValue(t *testing.T) {
+	cases := []struct {
+		raw    string
+		expect *ldbpb.Value
+	}{
+		{`null`, &ldbpb.Value{Kind: &ldbpb.Value_NullValue{NullValue: true}}},
+		{`true`, &ldbpb.Value{Kind: &ldbpb.Value_BoolValue{BoolValue: true}}},
+		{`"yes"`, &ldbpb.Value{Kind: &ldbpb.Value_StringValue{StringValue: "yes"}}},
+		{`177`, &ldbpb.Value{Kind: &ldbpb.Value_IntValue{IntValue: 177}}},
+		{`-1.5`, &ldbpb.Value{Kind: &ldbpb.Value_DoubleValue{DoubleValue: -1.5}}},
+		{`12.30`, &ldbpb.Value{Kind: &ldbpb.Value_StringValue{StringValue: "12.30"}}},
+		{`18446744073709551615`, &ldbpb.Value{Kind: &ldbpb.Value_StringValue{StringValue: "18446744073709551615"}}},
+		{`{"a":1}`, &ldbpb.Value{Kind: &ldbpb.Value_JsonValue{JsonValue: []byte(`{"a":1}`)}}},
+	}
+
+	for _, c := range cases {
+		if v := pbValue(json.RawMessage(c.raw)); !proto.Equal(v, c.expect) {
+			t.Errorf("%s: expect %v, got %v", c.raw, c.expect, v)
+		}
+	}
+}
@@ -1,10 +1,19 @@
 package mw2ldb
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/auth"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/pkg/errors"
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (c *Server) startHttpServer() {
@@ -14,9 +23,26 @@ func (c *Server) startHttpServer() {
 	}
 
 	logger := c.logger.With(zap.String("sub", "http"))
+	authenticator := auth.New(c.Auth)
 	m := func(ctx *fasthttp.RequestCtx) {
 		path := string(ctx.Path())
-		logger.Sugar().Infof(`path: %s, args: %s`, path, ctx.QueryArgs().String())
+		logger.Sugar().Infof(`path: %s, args: %s`, path, auth.LogArgs(ctx.QueryArgs()))
+
+		if strings.HasPrefix(path, "/v1/") {
+			c.serveV1(ctx, authenticator, path[len("/v1"):], logger)
+			return
+		}
+
+		client, err := c.authorize(authenticator, ctx, path)
+		if err != nil {
+			status := 401
+			if err == auth.ErrForbidden {
+				status = 403
+			}
+			ctx.Error(err.Error()+"\n", status)
+			return
+		}
+
 		switch path {
 		case "/log":
 			args := ctx.QueryArgs()
@@ -28,14 +54,20 @@ func (c *Server) startHttpServer() {
 				return
 			}
 
+			opt.Reveal = client != nil && client.Decrypt
+
 			opt.BeginTime, opt.EndTime = string(args.Peek(`start`)), string(args.Peek(`end`))
-			if (len(opt.BeginTime) > 0 && len(opt.BeginTime) != len(TimestampMin)) ||
-				(len(opt.EndTime) > 0 && len(opt.EndTime) != len(TimestampMin)) {
-				ctx.Error("bad start/end\n", 500)
+			opt.TZ = string(args.Peek(`tz`))
+			if _, _, err := logRange(opt.BeginTime, opt.EndTime, opt.TZ); err != nil {
+				ctx.Error(err.Error()+"\n", 500)
 				return
 			}
 
 			opt.Reverse = args.GetBool(`reverse`)
+			opt.Cursor = string(args.Peek(`cursor`))
+			opt.Where, opt.Fields = string(args.Peek(`where`)), string(args.Peek(`fields`))
+			opt.Type, opt.Changed = string(args.Peek(`type`)), string(args.Peek(`changed`))
+			opt.Position = string(args.Peek(`position`))
 
 			var err error
 			opt.Offset, err = args.GetUint(`offset`)
@@ -58,12 +90,16 @@ func (c *Server) startHttpServer() {
 				opt.Offset = 0
 			}
 
-			resArr, err := c.dumper.QueryLog(opt)
+			resArr, next, err := c.dumper.QueryLog(opt)
 			if err != nil {
 				ctx.Error(err.Error()+"\n", 500)
 				return
 			}
 
+			if len(next) > 0 {
+				ctx.Response.Header.Set("X-Next-Cursor", next)
+			}
+
 			bs, _ := json.Marshal(resArr)
 			_, _ = ctx.WriteString(string(bs))
 			_, _ = ctx.WriteString("\n")
@@ -77,7 +113,18 @@ func (c *Server) startHttpServer() {
 				return
 			}
 
+			opt.Reveal = client != nil && client.Decrypt
+
 			opt.PkID = string(args.Peek(`pk`))
+			opt.At, opt.TZ = string(args.Peek(`at`)), string(args.Peek(`tz`))
+			opt.Cursor = string(args.Peek(`cursor`))
+			opt.Reverse = args.GetBool(`reverse`)
+			opt.Where, opt.Fields = string(args.Peek(`where`)), string(args.Peek(`fields`))
+			opt.IncludeDeleted = args.GetBool(`include_deleted`)
+			if len(opt.At) > 0 && len(opt.PkID) == 0 {
+				ctx.Error("bad at/pk\n", 500)
+				return
+			}
 
 			var err error
 			opt.Offset, err = args.GetUint(`offset`)
@@ -100,16 +147,257 @@ func (c *Server) startHttpServer() {
 				opt.Offset = 0
 			}
 
-			resArr, err := c.dumper.QueryRow(opt)
+			resArr, next, err := c.dumper.QueryRow(opt)
 			if err != nil {
 				ctx.Error(err.Error()+"\n", 500)
 				return
 			}
 
+			if len(next) > 0 {
+				ctx.Response.Header.Set("X-Next-Cursor", next)
+			}
 
 			bs, _ := json.Marshal(resArr)
 			_, _ = ctx.WriteString(string(bs))
 			_, _ = ctx.WriteString("\n")
+		case "/history":
+			args := ctx.QueryArgs()
+
+			var opt HistOpt
+			opt.Database, opt.Table = string(args.Peek(`db`)), string(args.Peek(`tb`))
+			if len(opt.Database) == 0 || len(opt.Table) == 0 {
+				ctx.Error("bad database/table\n", 500)
+				return
+			}
+
+			opt.Reveal = client != nil && client.Decrypt
+
+			opt.PkID = string(args.Peek(`pk`))
+			if len(opt.PkID) == 0 {
+				ctx.Error("bad pk\n", 500)
+				return
+			}
+
+			opt.Reverse = args.GetBool(`reverse`)
+			opt.Cursor = string(args.Peek(`cursor`))
+
+			var err error
+			opt.Offset, err = args.GetUint(`offset`)
+			if err != nil && err != fasthttp.ErrNoArgValue {
+				ctx.Error("bad offset\n", 500)
+				return
+			}
+
+			opt.Limit, err = args.GetUint(`limit`)
+			if err != nil && err != fasthttp.ErrNoArgValue {
+				ctx.Error("bad limit\n", 500)
+				return
+			}
+
+			if opt.Limit < 0 {
+				opt.Limit = 0
+			}
+
+			if opt.Offset < 0 {
+				opt.Offset = 0
+			}
+
+			resArr, next, err := c.dumper.QueryHistory(opt)
+			if err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			if len(next) > 0 {
+				ctx.Response.Header.Set("X-Next-Cursor", next)
+			}
+
+			bs, _ := json.Marshal(resArr)
+			_, _ = ctx.WriteString(string(bs))
+			_, _ = ctx.WriteString("\n")
+		case "/flashback":
+			args := ctx.QueryArgs()
+
+			var opt FlashbackOpt
+			opt.Database, opt.Table = string(args.Peek(`db`)), string(args.Peek(`tb`))
+			if len(opt.Database) == 0 || len(opt.Table) == 0 {
+				ctx.Error("bad database/table\n", 500)
+				return
+			}
+
+			opt.BeginTime, opt.EndTime = string(args.Peek(`start`)), string(args.Peek(`end`))
+			opt.TZ = string(args.Peek(`tz`))
+			if _, _, err := logRange(opt.BeginTime, opt.EndTime, opt.TZ); err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			opt.PkID = string(args.Peek(`pk`))
+			opt.Reveal = client != nil && client.Decrypt
+			opt.AllowLossy = args.GetBool(`allow_lossy`)
+
+			buf := &bytes.Buffer{}
+			if err := c.dumper.Flashback(opt, buf); err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			ctx.SetContentType("text/plain; charset=utf-8")
+			_, _ = ctx.Write(buf.Bytes())
+		case "/export":
+			args := ctx.QueryArgs()
+
+			var opt ExportOpt
+			opt.Database, opt.Table = string(args.Peek(`db`)), string(args.Peek(`tb`))
+			if len(opt.Database) == 0 || len(opt.Table) == 0 {
+				ctx.Error("bad database/table\n", 500)
+				return
+			}
+
+			opt.Reveal = client != nil && client.Decrypt
+
+			opt.Format = string(args.Peek(`format`))
+			switch opt.Format {
+			case "", FormatNDJSON:
+				ctx.SetContentType("application/x-ndjson")
+			case FormatCSV:
+				ctx.SetContentType("text/csv; charset=utf-8")
+			default:
+				ctx.Error("bad format\n", 500)
+				return
+			}
+
+			opt.Log = string(args.Peek(`source`)) == "log"
+			opt.BeginTime, opt.EndTime = string(args.Peek(`start`)), string(args.Peek(`end`))
+			opt.TZ = string(args.Peek(`tz`))
+			if _, _, err := logRange(opt.BeginTime, opt.EndTime, opt.TZ); err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			c.streamExport(ctx, opt, logger)
+		case "/subscribe":
+			args := ctx.QueryArgs()
+
+			opt := SubscribeOpt{Offset: -1}
+			opt.Database, opt.Table = string(args.Peek(`db`)), string(args.Peek(`tb`))
+			if len(opt.Database) == 0 || len(opt.Table) == 0 {
+				ctx.Error("bad database/table\n", 500)
+				return
+			}
+
+			opt.Reveal = client != nil && client.Decrypt
+
+			if args.Has(`offset`) {
+				offset, err := strconv.ParseInt(string(args.Peek(`offset`)), 10, 64)
+				if err != nil || offset < 0 {
+					ctx.Error("bad offset\n", 500)
+					return
+				}
+				opt.Offset = offset
+			}
+
+			opt.Cursor = string(args.Peek(`cursor`))
+			if id := ctx.Request.Header.Peek("Last-Event-ID"); len(id) > 0 { // 浏览器断线重连
+				opt.Cursor = string(id)
+			}
+
+			opt.Where, opt.Fields = string(args.Peek(`where`)), string(args.Peek(`fields`))
+			opt.Type, opt.Changed = string(args.Peek(`type`)), string(args.Peek(`changed`))
+			if _, err := newFilter(opt.Where, opt.Type, opt.Changed, opt.Fields); err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			c.streamSubscribe(ctx, opt, logger)
+		case "/tx":
+			xid, err := strconv.ParseInt(string(ctx.QueryArgs().Peek(`xid`)), 10, 64)
+			if err != nil {
+				ctx.Error("bad xid\n", 500)
+				return
+			}
+
+			resArr, err := c.dumper.QueryTx(TxOpt{Xid: xid, Reveal: client != nil && client.Decrypt})
+			if err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			bs, _ := json.Marshal(allowRecords(client, resArr))
+			_, _ = ctx.WriteString(string(bs))
+			_, _ = ctx.WriteString("\n")
+		case "/schema":
+			args := ctx.QueryArgs()
+
+			var opt SchemaOpt
+			opt.Database, opt.Table = string(args.Peek(`db`)), string(args.Peek(`tb`))
+			opt.At, opt.TZ = string(args.Peek(`at`)), string(args.Peek(`tz`))
+			if len(opt.Database) == 0 || len(opt.Table) == 0 {
+				ctx.Error("bad database/table\n", 500)
+				return
+			}
+
+			resArr, err := c.dumper.Schema(opt)
+			if err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			bs, _ := json.Marshal(resArr)
+			_, _ = ctx.WriteString(string(bs))
+			_, _ = ctx.WriteString("\n")
+		case "/tables":
+			resArr, err := c.dumper.Tables(ctx.QueryArgs().GetBool(`exact`))
+			if err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			bs, _ := json.Marshal(allowTables(client, resArr))
+			_, _ = ctx.WriteString(string(bs))
+			_, _ = ctx.WriteString("\n")
+		case "/stats":
+			res, err := c.dumper.Stats()
+			if err != nil {
+				ctx.Error(err.Error()+"\n", 500)
+				return
+			}
+
+			bs, _ := json.Marshal(res)
+			_, _ = ctx.WriteString(string(bs))
+			_, _ = ctx.WriteString("\n")
+		case "/admin/backup":
+			c.streamBackup(ctx, logger)
+		case "/admin/offset":
+			if !ctx.IsPost() {
+				res, err := c.offsetStatus()
+				if err != nil {
+					ctx.Error(err.Error()+"\n", 500)
+					return
+				}
+
+				bs, _ := json.Marshal(res)
+				_, _ = ctx.WriteString(string(bs))
+				_, _ = ctx.WriteString("\n")
+				return
+			}
+
+			r, err := c.rewind(ctx, client)
+			if err != nil {
+				status := 500
+				switch err {
+				case errNotConsuming:
+					status = 503
+				case offsets.ErrTxOpen:
+					status = 409
+				}
+				ctx.Error(err.Error()+"\n", status)
+				return
+			}
+
+			bs, _ := json.Marshal(r)
+			_, _ = ctx.WriteString(string(bs))
+			_, _ = ctx.WriteString("\n")
 		case "/del":
 
 			_, _ = ctx.WriteString("done")
@@ -119,8 +407,159 @@ func (c *Server) startHttpServer() {
 	}
 
 	logger.Sugar().Infof(`listen %s`, addr)
-	if err := fasthttp.ListenAndServe(addr, m); err != nil {
+	ln, err := auth.Listen(c.Auth, addr)
+	if err != nil {
+		logger.Fatal(`listen fail`, zap.String("e", err.Error()))
+		os.Exit(1)
+	}
+
+	if err := fasthttp.Serve(ln, m); err != nil {
 		logger.Fatal(`listen fail`, zap.String("e", err.Error()))
 		os.Exit(1)
 	}
 }
+
+// authorize 认证并检查请求的库表是否在 token 的范围内, 没有配置认证时返回 nil
+func (c *Server) authorize(a *auth.Authenticator, ctx *fasthttp.RequestCtx, path string) (*auth.Client, error) {
+	client, err := authenticate(a, ctx)
+	if err != nil || client == nil {
+		return nil, err
+	}
+
+	switch {
+	case path == "/tables", path == "/tx": // 按范围过滤结果
+		return client, nil
+	case path == "/stats", path == "/del", strings.HasPrefix(path, "/admin/"):
+		return client, requireAdmin(client)
+	}
+
+	args := ctx.QueryArgs()
+	return client, allowTable(client, string(args.Peek(`db`)), string(args.Peek(`tb`)))
+}
+
+func authenticate(a *auth.Authenticator, ctx *fasthttp.RequestCtx) (*auth.Client, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return a.Authenticate(ctx)
+}
+
+func requireAdmin(client *auth.Client) error {
+	if client != nil && !client.Admin {
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+func allowTable(client *auth.Client, database, table string) error {
+	if client != nil && !client.Allow(database, table) {
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+func (c *Server) streamExport(ctx *fasthttp.RequestCtx, opt ExportOpt, logger *zap.Logger) {
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := c.dumper.Export(opt, w); err != nil {
+			logger.Error(`export`, zap.String("e", err.Error()))
+		}
+	})
+}
+
+func (c *Server) streamSubscribe(ctx *fasthttp.RequestCtx, opt SubscribeOpt, logger *zap.Logger) {
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := c.dumper.Subscribe(opt, w); err != nil {
+			logger.Info(`subscribe`, zap.String("table", opt.Database+"."+opt.Table), zap.String("e", err.Error()))
+		}
+	})
+}
+
+func (c *Server) streamBackup(ctx *fasthttp.RequestCtx, logger *zap.Logger) {
+	ctx.SetContentType("application/octet-stream")
+	ctx.Response.Header.Set("Content-Disposition", `attachment; filename="`+c.LDBName+`.backup"`)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := c.dumper.Backup(w); err != nil {
+			logger.Error(`backup`, zap.String("e", err.Error()))
+		}
+	})
+}
+
+// allowRecords 去掉 client 范围之外的表的 log:
+func allowRecords(client *auth.Client, resArr []interface{}) []interface{} {
+	if client == nil {
+		return resArr
+	}
+
+	allowed := resArr[:0]
+	for _, r := range resArr {
+		var database, table string
+		_ = json.Unmarshal(r.(record).V["database"], &database)
+		_ = json.Unmarshal(r.(record).V["table"], &table)
+		if client.Allow(database, table) {
+			allowed = append(allowed, r)
+		}
+	}
+	return allowed
+}
+
+// allowTables 去掉 client 范围之外的表
+func allowTables(client *auth.Client, resArr []interface{}) []interface{} {
+	if client == nil {
+		return resArr
+	}
+
+	allowed := resArr[:0]
+	for _, r := range resArr {
+		name := r.(*TableStat).Table
+		dot := strings.IndexByte(name, '.')
+		if dot >= 0 && client.Allow(name[:dot], name[dot+1:]) {
+			allowed = append(allowed, r)
+		}
+	}
+	return allowed
+}
+
+func (c *Server) offsetStatus() (map[string]int64, error) {
+	last, err := c.dumper.LastOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	if last == offsets.Unset {
+		last = -1
+	}
+	res := map[string]int64{"partition": 0, "offset": last}
+	if c.client != nil {
+		res["oldest"], _ = c.client.GetOffset(c.Topic, 0, sarama.OffsetOldest)
+		res["newest"], _ = c.client.GetOffset(c.Topic, 0, sarama.OffsetNewest)
+	}
+	return res, nil
+}
+
+var errNotConsuming = errors.New("not consuming kafka")
+
+// rewind 把重置 offset 的请求交给消费循环, 等待执行完成
+func (c *Server) rewind(ctx *fasthttp.RequestCtx, client *auth.Client) (*offsets.Rewind, error) {
+	if c.rewinds == nil {
+		return nil, errNotConsuming
+	}
+
+	r, err := offsets.ParseRewind(ctx.QueryArgs())
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+
+	r.Remote, r.Time = ctx.RemoteAddr().String(), time.Now().Format(time.RFC3339)
+	if client != nil {
+		r.Remote = client.Name + "@" + r.Remote
+	}
+
+	req := &rewindReq{r: r, done: make(chan error, 1)}
+	c.rewinds <- req
+	if err := <-req.done; err != nil {
+		return nil, err
+	}
+	return r, nil
+}
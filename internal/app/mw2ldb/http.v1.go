@@ -0,0 +1,425 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/helloshiki/maxwell-output/pkg/auth"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+// /v1 接口, 见 api/openapi.yaml. 请求和响应都是 json, 错误统一为 {"error": "...", "code": "..."}
+
+// apiError 是 /v1 的错误响应
+type apiError struct {
+	Status int    `json:"-"`
+	Msg    string `json:"error"`
+	Code   string `json:"code"` // bad_request, unauthorized, forbidden, not_found, method_not_allowed, conflict, unavailable, internal
+}
+
+func (e *apiError) Error() string {
+	return e.Msg
+}
+
+// Page 是列表接口的响应, 有下一页时 next_cursor 不为空
+type Page struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// Item 是单个对象的响应
+type Item struct {
+	Data interface{} `json:"data"`
+}
+
+// Row 是一行的当前值
+type Row struct {
+	Key  string                 `json:"key"`
+	Data map[string]interface{} `json:"data"`
+	Meta map[string]interface{} `json:"meta,omitempty"` // WithoutMeta 时为空
+}
+
+// Change 是 log 或 history 中的一次变更
+type Change struct {
+	Key      string                 `json:"key"`
+	Database string                 `json:"database"`
+	Table    string                 `json:"table"`
+	Offset   int64                  `json:"offset"`
+	Type     string                 `json:"type"`
+	Ts       int64                  `json:"ts"`
+	Xid      int64                  `json:"xid,omitempty"`
+	Commit   bool                   `json:"commit,omitempty"`
+	Position string                 `json:"position,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Old      map[string]interface{} `json:"old,omitempty"`
+}
+
+// decodeObject 把 json 对象解码成 map, 数字保留原样 (比如 0E-8)
+func decodeObject(raw json.RawMessage) map[string]interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	_ = dec.Decode(&m)
+	return m
+}
+
+func (c *Server) toRow(r record) *Row {
+	if c.WithoutMeta {
+		data := make(map[string]interface{}, len(r.V))
+		for k, v := range r.V {
+			data[k] = decodeObject(json.RawMessage(`{"v":` + string(v) + `}`))["v"]
+		}
+		return &Row{Key: r.K, Data: data}
+	}
+
+	return &Row{Key: r.K, Data: decodeObject(r.V["data"]), Meta: decodeObject(r.V["meta"])}
+}
+
+func toChange(r record) *Change {
+	e := &Change{Key: r.K, Offset: keyOffset([]byte(r.K))}
+	_ = json.Unmarshal(r.V["database"], &e.Database)
+	_ = json.Unmarshal(r.V["table"], &e.Table)
+	_ = json.Unmarshal(r.V["type"], &e.Type)
+	_ = json.Unmarshal(r.V["ts"], &e.Ts)
+	_ = json.Unmarshal(r.V["xid"], &e.Xid)
+	_ = json.Unmarshal(r.V["commit"], &e.Commit)
+	_ = json.Unmarshal(r.V["position"], &e.Position)
+	e.Data, e.Old = decodeObject(r.V["data"]), decodeObject(r.V["old"])
+	return e
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	bs, _ := json.Marshal(v)
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	_, _ = ctx.Write(bs)
+	_, _ = ctx.WriteString("\n")
+}
+
+// writeError 按错误类型选择状态码
+func writeError(ctx *fasthttp.RequestCtx, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{Status: 500, Msg: err.Error(), Code: "internal"}
+		switch err.(type) {
+		case *badRequestError:
+			e.Status, e.Code = 400, "bad_request"
+		}
+
+		switch err {
+		case leveldb.ErrNotFound:
+			e.Status, e.Code = 404, "not_found"
+		case auth.ErrUnauthorized:
+			e.Status, e.Code = 401, "unauthorized"
+		case auth.ErrForbidden:
+			e.Status, e.Code = 403, "forbidden"
+		case errNotConsuming:
+			e.Status, e.Code = 503, "unavailable"
+		case offsets.ErrTxOpen:
+			e.Status, e.Code = 409, "conflict"
+		}
+	}
+
+	writeJSON(ctx, e.Status, e)
+}
+
+func methodNotAllowed(ctx *fasthttp.RequestCtx, methods ...string) error {
+	for _, m := range methods {
+		if string(ctx.Method()) == m {
+			return nil
+		}
+	}
+
+	ctx.Response.Header.Set("Allow", strings.Join(methods, ", "))
+	return &apiError{Status: 405, Msg: "method not allowed", Code: "method_not_allowed"}
+}
+
+// argInt 读取非负整数参数, 没有时返回 def
+func argInt(args *fasthttp.Args, name string, def int) (int, error) {
+	if !args.Has(name) {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(string(args.Peek(name)))
+	if err != nil || n < 0 {
+		return 0, badRequest("bad %s", name)
+	}
+	return n, nil
+}
+
+func (c *Server) serveV1(ctx *fasthttp.RequestCtx, a *auth.Authenticator, path string, logger *zap.Logger) {
+	if err := c.routeV1(ctx, a, path, logger); err != nil {
+		writeError(ctx, err)
+	}
+}
+
+// routeV1 分发 /v1 请求, path 不包含 /v1 前缀
+func (c *Server) routeV1(ctx *fasthttp.RequestCtx, a *auth.Authenticator, path string, logger *zap.Logger) error {
+	client, err := authenticate(a, ctx)
+	if err != nil {
+		return err
+	}
+
+	seg := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(seg) == 1 && seg[0] == "tables":
+		if err := methodNotAllowed(ctx, "GET"); err != nil {
+			return err
+		}
+
+		resArr, err := c.dumper.Tables(ctx.QueryArgs().GetBool(`exact`))
+		if err != nil {
+			return err
+		}
+		writeJSON(ctx, 200, &Page{Data: allowTables(client, resArr)})
+		return nil
+	case len(seg) == 1 && seg[0] == "stats":
+		if err := methodNotAllowed(ctx, "GET"); err != nil {
+			return err
+		}
+
+		if err := requireAdmin(client); err != nil {
+			return err
+		}
+
+		res, err := c.dumper.Stats()
+		if err != nil {
+			return err
+		}
+		writeJSON(ctx, 200, &Item{Data: res})
+		return nil
+	case len(seg) == 2 && seg[0] == "tx":
+		if err := methodNotAllowed(ctx, "GET"); err != nil {
+			return err
+		}
+
+		xid, err := strconv.ParseInt(seg[1], 10, 64)
+		if err != nil {
+			return badRequest("bad xid")
+		}
+
+		resArr, err := c.dumper.QueryTx(TxOpt{Xid: xid, Reveal: client != nil && client.Decrypt})
+		if err != nil {
+			return err
+		}
+
+		changes := make([]*Change, 0, len(resArr))
+		for _, r := range allowRecords(client, resArr) {
+			changes = append(changes, toChange(r.(record)))
+		}
+		writeJSON(ctx, 200, &Page{Data: changes})
+		return nil
+	case len(seg) == 2 && seg[0] == "admin":
+		if err := requireAdmin(client); err != nil {
+			return err
+		}
+		return c.routeV1Admin(ctx, client, seg[1], logger)
+	case len(seg) >= 4 && seg[0] == "tables":
+		database, table := seg[1], seg[2]
+		if err := allowTable(client, database, table); err != nil {
+			return err
+		}
+
+		if err := methodNotAllowed(ctx, "GET"); err != nil {
+			return err
+		}
+		return c.routeV1Table(ctx, client, database, table, seg[3:], logger)
+	}
+
+	return &apiError{Status: 404, Msg: "no route for " + path, Code: "not_found"}
+}
+
+func (c *Server) routeV1Admin(ctx *fasthttp.RequestCtx, client *auth.Client, name string, logger *zap.Logger) error {
+	switch name {
+	case "backup":
+		if err := methodNotAllowed(ctx, "GET"); err != nil {
+			return err
+		}
+
+		c.streamBackup(ctx, logger)
+		return nil
+	case "offset":
+		if err := methodNotAllowed(ctx, "GET", "POST"); err != nil {
+			return err
+		}
+
+		if ctx.IsGet() {
+			res, err := c.offsetStatus()
+			if err != nil {
+				return err
+			}
+			writeJSON(ctx, 200, &Item{Data: res})
+			return nil
+		}
+
+		r, err := c.rewind(ctx, client)
+		if err != nil {
+			return err
+		}
+		writeJSON(ctx, 200, &Item{Data: r})
+		return nil
+	}
+
+	return &apiError{Status: 404, Msg: "no route for /admin/" + name, Code: "not_found"}
+}
+
+// routeV1Table 处理 /v1/tables/{db}/{tb}/ 下的请求
+func (c *Server) routeV1Table(ctx *fasthttp.RequestCtx, client *auth.Client, database, table string, seg []string, logger *zap.Logger) error {
+	args := ctx.QueryArgs()
+	reveal := client != nil && client.Decrypt
+
+	limit, err := argInt(args, `limit`, 100)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(seg) == 1 && seg[0] == "rows":
+		opt := RowOpt{Database: database, Table: table, Limit: limit, Reveal: reveal}
+		opt.Cursor, opt.Reverse = string(args.Peek(`cursor`)), args.GetBool(`reverse`)
+		opt.Where, opt.Fields = string(args.Peek(`where`)), string(args.Peek(`fields`))
+		opt.IncludeDeleted = args.GetBool(`include_deleted`)
+
+		resArr, next, err := c.dumper.QueryRow(opt)
+		if err != nil {
+			return err
+		}
+
+		rows := make([]*Row, 0, len(resArr))
+		for _, r := range resArr {
+			rows = append(rows, c.toRow(r.(record)))
+		}
+		writeJSON(ctx, 200, &Page{Data: rows, NextCursor: next})
+		return nil
+	case len(seg) == 2 && seg[0] == "rows":
+		opt := RowOpt{Database: database, Table: table, PkID: seg[1], Limit: 1, Reveal: reveal}
+		opt.At, opt.TZ, opt.Fields = string(args.Peek(`at`)), string(args.Peek(`tz`)), string(args.Peek(`fields`))
+		opt.IncludeDeleted = args.GetBool(`include_deleted`)
+
+		resArr, _, err := c.dumper.QueryRow(opt)
+		if err != nil {
+			return err
+		}
+
+		if len(resArr) == 0 {
+			return leveldb.ErrNotFound
+		}
+		writeJSON(ctx, 200, &Item{Data: c.toRow(resArr[0].(record))})
+		return nil
+	case len(seg) == 3 && seg[0] == "rows" && seg[2] == "history":
+		opt := HistOpt{Database: database, Table: table, PkID: seg[1], Limit: limit, Reveal: reveal}
+		opt.Cursor, opt.Reverse = string(args.Peek(`cursor`)), args.GetBool(`reverse`)
+
+		resArr, next, err := c.dumper.QueryHistory(opt)
+		if err != nil {
+			return err
+		}
+
+		changes := make([]*Change, 0, len(resArr))
+		for _, r := range resArr {
+			changes = append(changes, toChange(r.(record)))
+		}
+		writeJSON(ctx, 200, &Page{Data: changes, NextCursor: next})
+		return nil
+	case len(seg) == 1 && seg[0] == "schema":
+		opt := SchemaOpt{Database: database, Table: table, At: string(args.Peek(`at`)), TZ: string(args.Peek(`tz`))}
+		resArr, err := c.dumper.Schema(opt)
+		if err != nil {
+			return err
+		}
+
+		writeJSON(ctx, 200, &Page{Data: resArr})
+		return nil
+	case len(seg) == 1 && seg[0] == "logs":
+		opt := LogOpt{Database: database, Table: table, Limit: limit, Reveal: reveal}
+		opt.Cursor, opt.Reverse = string(args.Peek(`cursor`)), args.GetBool(`reverse`)
+		opt.Where, opt.Fields = string(args.Peek(`where`)), string(args.Peek(`fields`))
+		opt.Type, opt.Changed = string(args.Peek(`type`)), string(args.Peek(`changed`))
+		opt.Position = string(args.Peek(`position`))
+		opt.BeginTime, opt.EndTime, opt.TZ = string(args.Peek(`start`)), string(args.Peek(`end`)), string(args.Peek(`tz`))
+		if _, _, err := logRange(opt.BeginTime, opt.EndTime, opt.TZ); err != nil {
+			return err
+		}
+
+		resArr, next, err := c.dumper.QueryLog(opt)
+		if err != nil {
+			return err
+		}
+
+		changes := make([]*Change, 0, len(resArr))
+		for _, r := range resArr {
+			changes = append(changes, toChange(r.(record)))
+		}
+		writeJSON(ctx, 200, &Page{Data: changes, NextCursor: next})
+		return nil
+	case len(seg) == 1 && seg[0] == "flashback":
+		opt := FlashbackOpt{Database: database, Table: table, PkID: string(args.Peek(`pk`)), Reveal: reveal}
+		opt.AllowLossy = args.GetBool(`allow_lossy`)
+		opt.BeginTime, opt.EndTime, opt.TZ = string(args.Peek(`start`)), string(args.Peek(`end`)), string(args.Peek(`tz`))
+		if _, _, err := logRange(opt.BeginTime, opt.EndTime, opt.TZ); err != nil {
+			return err
+		}
+
+		buf := &bytes.Buffer{}
+		if err := c.dumper.Flashback(opt, buf); err != nil {
+			return err
+		}
+
+		ctx.SetContentType("text/plain; charset=utf-8")
+		_, _ = ctx.Write(buf.Bytes())
+		return nil
+	case len(seg) == 1 && seg[0] == "export":
+		opt := ExportOpt{Database: database, Table: table, Reveal: reveal}
+		opt.Format, opt.Log = string(args.Peek(`format`)), string(args.Peek(`source`)) == "log"
+		switch opt.Format {
+		case "", FormatNDJSON:
+			ctx.SetContentType("application/x-ndjson")
+		case FormatCSV:
+			ctx.SetContentType("text/csv; charset=utf-8")
+		default:
+			return badRequest("bad format")
+		}
+
+		opt.BeginTime, opt.EndTime, opt.TZ = string(args.Peek(`start`)), string(args.Peek(`end`)), string(args.Peek(`tz`))
+		if _, _, err := logRange(opt.BeginTime, opt.EndTime, opt.TZ); err != nil {
+			return err
+		}
+
+		c.streamExport(ctx, opt, logger)
+		return nil
+	case len(seg) == 1 && seg[0] == "subscribe":
+		opt := SubscribeOpt{Database: database, Table: table, Offset: -1, Reveal: reveal}
+		if args.Has(`offset`) {
+			offset, err := strconv.ParseInt(string(args.Peek(`offset`)), 10, 64)
+			if err != nil || offset < 0 {
+				return badRequest("bad offset")
+			}
+			opt.Offset = offset
+		}
+
+		opt.Cursor = string(args.Peek(`cursor`))
+		if id := ctx.Request.Header.Peek("Last-Event-ID"); len(id) > 0 {
+			opt.Cursor = string(id)
+		}
+
+		opt.Where, opt.Fields = string(args.Peek(`where`)), string(args.Peek(`fields`))
+		opt.Type, opt.Changed = string(args.Peek(`type`)), string(args.Peek(`changed`))
+		if _, err := newFilter(opt.Where, opt.Type, opt.Changed, opt.Fields); err != nil {
+			return err
+		}
+
+		c.streamSubscribe(ctx, opt, logger)
+		return nil
+	}
+
+	return &apiError{Status: 404, Msg: "no route for /tables/" + database + "/" + table + "/" + strings.Join(seg, "/"), Code: "not_found"}
+}
@@ -0,0 +1,60 @@
+package mw2ldb
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/helloshiki/maxwell-output/pkg/auth"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+func TestAllowTables(t *testing.T) {
+	client := &auth.Client{Scopes: []string{"hsn.*"}}
+	res := allowTables(client, []interface{}{
+		&TableStat{Table: "hsn.user_info"},
+		&TableStat{Table: "other.user_info"},
+		&TableStat{Table: "user_info"}, // 没有库名时不能 panic
+	})
+	if len(res) != 1 || res[0].(*TableStat).Table != "hsn.user_info" {
+		t.Errorf("bad tables: %v", res)
+	}
+}
+
+func TestServeV1Errors(t *testing.T) {
+	d := newTestDump(t)
+	c := &Server{logger: zap.NewNop(), config: d.config, dumper: d}
+
+	get := func(uri string) (int, *apiError) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/v1" + uri)
+		c.serveV1(ctx, nil, strings.TrimPrefix(string(ctx.Path()), "/v1"), c.logger)
+
+		var e apiError
+		if err := json.Unmarshal(ctx.Response.Body(), &e); err != nil {
+			t.Fatal(err)
+		}
+		return ctx.Response.StatusCode(), &e
+	}
+
+	for uri, want := range map[string]struct {
+		status int
+		code   string
+	}{
+		"/tables/hsn/user_info/logs?limit=-1": {400, "bad_request"},
+		"/tx/x":                               {400, "bad_request"},
+		"/tables/hsn/user_info/rows/1":        {404, "not_found"},
+		"/nothing":                            {404, "not_found"},
+	} {
+		if status, e := get(uri); status != want.status || e.Code != want.code || len(e.Msg) == 0 {
+			t.Errorf("%s: got %d %+v", uri, status, e)
+		}
+	}
+
+	// 其他错误是 500
+	_ = d.ldb.Close()
+	if status, e := get("/tables/hsn/user_info/logs"); status != 500 || e.Code != "internal" {
+		t.Errorf("closed db: got %d %+v", status, e)
+	}
+}
@@ -4,9 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/helloshiki/maxwell-output/pkg/txbatch"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"path"
+	"strings"
 	"time"
 )
 
@@ -15,6 +22,14 @@ type Server struct {
 	configFile string
 	*config
 	dumper Dump
+
+	client  sarama.Client
+	rewinds chan *rewindReq // 为 nil 时没有消费 kafka
+}
+
+type rewindReq struct {
+	r    *offsets.Rewind
+	done chan error
 }
 
 type opts struct {
@@ -46,6 +61,180 @@ func (c *Server) Start() error {
 	return c.dump()
 }
 
+func (c *Server) newDump() *timedDump {
+	return &timedDump{
+		logger:  c.logger,
+		size:    c.CacheSize,
+		config:  c.config,
+		dir:     c.Dir,
+		ldbName: c.LDBName,
+		feed:    newBroker(),
+		batcher: &txbatch.Batcher{Size: c.CacheSize, TxOnly: c.TxBatch, MaxSize: c.MaxBatchSize},
+	}
+}
+
+// open 只读打开 leveldb, 不连接 kafka, 供离线命令和只查询模式使用
+func (c *Server) open() (*timedDump, error) {
+	cfg, err := parseConfig(c.configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c.config = cfg
+	dump := c.newDump()
+	dump.readOnly = true
+	if err := dump.Initialize(); err != nil {
+		return nil, err
+	}
+
+	return dump, nil
+}
+
+// Serve 只提供 http 和 grpc 查询, 不消费 kafka
+func (c *Server) Serve() error {
+	dump, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dump.ldb.Close() }()
+
+	if len(c.ListenAddr) == 0 && len(c.GRPCAddr) == 0 {
+		return errors.New("empty ListenAddr and GRPCAddr")
+	}
+
+	c.dumper = dump
+	if len(c.ListenAddr) == 0 {
+		c.startGrpcServer()
+		return nil
+	}
+
+	go c.startGrpcServer()
+	c.startHttpServer()
+	return nil
+}
+
+// AsOf 把一行或整张表还原到 at 时刻, 以 ndjson 输出
+func (c *Server) AsOf(opt RowOpt, w io.Writer) error {
+	dump, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dump.ldb.Close() }()
+
+	if len(opt.PkID) == 0 {
+		return dump.TableAsOf(opt, w)
+	}
+
+	resArr, _, err := dump.QueryRow(opt)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, r := range resArr {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flashback 离线生成回滚语句
+func (c *Server) Flashback(opt FlashbackOpt, w io.Writer) error {
+	dump, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dump.ldb.Close() }()
+
+	return dump.Flashback(opt, w)
+}
+
+// Export 离线导出整张表或一段 log
+func (c *Server) Export(opt ExportOpt, w io.Writer) error {
+	dump, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dump.ldb.Close() }()
+
+	return dump.Export(opt, w)
+}
+
+// SQLExport 离线导出重放语句
+func (c *Server) SQLExport(opt SQLExportOpt, w io.Writer) error {
+	dump, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dump.ldb.Close() }()
+
+	return dump.SQLExport(opt, w)
+}
+
+// Backup 备份到 w; url 不为空时用 token 从运行中的服务下载, 否则只读打开本地 leveldb
+func (c *Server) Backup(url, token string, w io.Writer) error {
+	if len(url) > 0 {
+		req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(url, "/")+"/admin/backup", nil)
+		if err != nil {
+			return err
+		}
+
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("backup: %s", resp.Status)
+		}
+
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+
+	dump, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dump.ldb.Close() }()
+
+	return dump.Backup(w)
+}
+
+// Restore 从备份重建 <Dir>/<LDBName>, 目标必须不存在
+func (c *Server) Restore(r io.Reader) error {
+	cfg, err := parseConfig(c.configFile)
+	if err != nil {
+		return err
+	}
+
+	c.config = cfg
+	count, err := restore(path.Join(c.Dir, c.LDBName), r)
+	if err != nil {
+		return err
+	}
+
+	dump, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dump.ldb.Close() }()
+
+	offset, err := dump.LastOffset()
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info(`restore`, zap.Uint64("count", count), zap.Int64("offset", offset))
+	return nil
+}
+
 func (c *Server) initialize() error {
 	//log := c.logger
 	return nil
@@ -65,22 +254,23 @@ func (c *Server) dump() error {
 	}
 
 	// consumer []string{"192.168.100.181:9092"}
-	consumer, err := sarama.NewConsumer(c.Brokers, config)
+	client, err := sarama.NewClient(c.Brokers, config)
 	if err != nil {
-		logger.Fatal("create consumer error", zap.String("e", err.Error()))
+		logger.Fatal("create client error", zap.String("e", err.Error()))
 		return err
 	}
 
-	defer func() { _ = consumer.Close() }()
+	defer func() { _ = client.Close() }()
 
-	var dump Dump = &timedDump{
-		logger:  c.logger,
-		size:    c.CacheSize,
-		config:  c.config,
-		dir:     c.Dir,
-		ldbName: c.LDBName,
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		logger.Fatal("create consumer error", zap.String("e", err.Error()))
+		return err
 	}
 
+	defer func() { _ = consumer.Close() }()
+
+	var dump Dump = c.newDump()
 	if err := dump.Initialize(); err != nil {
 		return err
 	}
@@ -90,8 +280,11 @@ func (c *Server) dump() error {
 		return err
 	}
 
-	if offset < 0 {
-		offset = sarama.OffsetOldest
+	if offset == offsets.Unset || c.ForceStartFrom {
+		offset, err = offsets.StartOffset(client, consumer, c.Topic, 0, c.StartFrom)
+		if err != nil {
+			return err
+		}
 	} else {
 		offset++
 	}
@@ -102,10 +295,20 @@ func (c *Server) dump() error {
 		log.Fatal("ConsumePartition", zap.String("e", err.Error()))
 		return err
 	}
-	defer func() { _ = partitionConsumer.Close() }()
 
+	// rewind 在消费协程内切换 rewinder.PC
+	rewinder := &offsets.Rewinder{Client: client, Consumer: consumer, Store: dump, Topic: c.Topic, Start: offset, PC: partitionConsumer}
+	defer func() {
+		if rewinder.PC != nil {
+			_ = rewinder.PC.Close()
+		}
+	}()
+
+	c.client = client
+	c.rewinds = make(chan *rewindReq)
 	c.dumper = dump
 	go c.startHttpServer()
+	go c.startGrpcServer()
 
 	tick := time.NewTicker(time.Second * 5)
 	delTick := time.NewTicker(time.Second * 15)
@@ -115,15 +318,25 @@ func (c *Server) dump() error {
 			if err := dump.Dump(nil); err != nil {
 				panic(err)
 			}
-		case msg := <-partitionConsumer.Messages():
+		case msg := <-rewinder.PC.Messages():
 			if err := dump.Dump(msg); err != nil {
 				panic(err)
 			}
+		case req := <-c.rewinds:
+			err := rewinder.Rewind(req.r)
+			req.done <- err
+			if err != nil && rewinder.PC == nil {
+				logger.Fatal(`rewind`, zap.String("e", err.Error()))
+				os.Exit(1)
+			}
 		case <-delTick.C:
-			//if err := dump.ClearLog(); err != nil {
-			//	panic(err)
-			//}
-		case err := <-partitionConsumer.Errors():
+			if err := dump.ClearLog(); err != nil {
+				panic(err)
+			}
+			if err := dump.PurgeDeleted(); err != nil {
+				panic(err)
+			}
+		case err := <-rewinder.PC.Errors():
 			logger.Fatal(`kafka`, zap.String("e", err.Error()))
 			os.Exit(1)
 		}
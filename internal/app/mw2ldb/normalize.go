@@ -0,0 +1,40 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+)
+
+// normalizeMsg 按表结构和 Normalize 配置处理消息中的 data 和 old, 在 transformMsg 之前调用
+func (d *timedDump) normalizeMsg(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	var r struct {
+		Database string `json:"database"`
+		Table    string `json:"table"`
+	}
+	if err := json.Unmarshal(msg.Value, &r); err != nil {
+		return msg
+	}
+
+	buf := &bytes.Buffer{}
+	table := makeDBTable(buf, []byte(r.Database), []byte(r.Table)).String()
+	c := d.TableConfig(table).Normalize
+	if c == nil {
+		return msg
+	}
+
+	m := *msg
+	m.Value = c.Message(d.schemas.Def(table), msg.Value)
+	return &m
+}
+
+// sqlNormalized 检查表的 Normalize 配置, 保存的值改变了原来的值时不能生成 flashback/sqlexport 的 sql
+func (d *timedDump) sqlNormalized(database, table string) error {
+	buf := &bytes.Buffer{}
+	if c := d.TableConfig(makeDBTable(buf, []byte(database), []byte(table)).String()).Normalize; !c.SQLSafe() {
+		return badRequest("%s.%s: values are changed by Normalize (scaled Decimal, FlattenJSON, SourceTZ or hex Binary) and can not be written back as sql",
+			database, table)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/redact"
+)
+
+// redactMsg 按表的 Redact 规则处理消息中的 data 和 old, 在 update/appendLog 之前调用
+func (d *timedDump) redactMsg(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	if d.redactor == nil {
+		return msg
+	}
+
+	var r struct {
+		Database string `json:"database"`
+		Table    string `json:"table"`
+	}
+	if err := json.Unmarshal(msg.Value, &r); err != nil {
+		return msg
+	}
+
+	buf := &bytes.Buffer{}
+	rules := d.TableConfig(makeDBTable(buf, []byte(r.Database), []byte(r.Table)).String()).Redact
+	if len(rules) == 0 {
+		return msg
+	}
+
+	m := *msg
+	m.Value = d.redactor.Message(rules, msg.Value)
+	return &m
+}
+
+// redactFunc 在查询时处理 data 和 old, 规则加上之前写入的数据也会脱敏
+type redactFunc func(data map[string]json.RawMessage)
+
+func (f redactFunc) apply(data map[string]json.RawMessage) {
+	if f != nil && data != nil {
+		f(data)
+	}
+}
+
+// queryRedact 返回表的查询脱敏函数, reveal 为 true 时解密 encrypt 的列; 没有规则时返回 nil
+func (d *timedDump) queryRedact(database, table string, reveal bool) redactFunc {
+	if d.redactor == nil {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	rules := d.TableConfig(makeDBTable(buf, []byte(database), []byte(table)).String()).Redact
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return func(data map[string]json.RawMessage) {
+		d.redactor.Apply(rules, data)
+		if reveal {
+			d.redactor.Reveal(rules, data)
+		}
+	}
+}
+
+// sqlRedact 返回 flashback/sqlexport 使用的脱敏函数. 保存的值已经脱敏, drop/hash/mask 的列
+// (以及 reveal 为 false 时 encrypt 的列) 生成的 sql 无法还原原来的值, 没有 allowLossy 时拒绝
+func (d *timedDump) sqlRedact(database, table string, reveal, allowLossy bool) (redactFunc, error) {
+	if d.redactor == nil {
+		return nil, nil
+	}
+
+	buf := &bytes.Buffer{}
+	for _, rule := range d.TableConfig(makeDBTable(buf, []byte(database), []byte(table)).String()).Redact {
+		if allowLossy || (rule.Action == redact.ActionEncrypt && reveal) {
+			continue
+		}
+		return nil, badRequest("%s.%s: column %s is redacted by %s and can not be restored, set allow lossy to generate sql anyway",
+			database, table, rule.Column, rule.Action)
+	}
+	return d.queryRedact(database, table, reveal), nil
+}
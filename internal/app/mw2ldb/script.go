@@ -0,0 +1,73 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+	"github.com/helloshiki/maxwell-output/pkg/script"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// scriptMsg 用表的 Script 处理消息, 在 transformMsg 之后, redactMsg 之前调用.
+// 返回空表示丢弃, 多条表示拆分; 脚本出错时按 OnError 保留或者丢弃原消息, stop 时返回错误
+func (d *timedDump) scriptMsg(msg *sarama.ConsumerMessage) ([]*sarama.ConsumerMessage, error) {
+	var r struct {
+		Type     string `json:"type"`
+		Database string `json:"database"`
+		Table    string `json:"table"`
+	}
+	if err := json.Unmarshal(msg.Value, &r); err != nil || schema.IsDDL(r.Type) {
+		return []*sarama.ConsumerMessage{msg}, nil
+	}
+
+	buf := &bytes.Buffer{}
+	table := makeDBTable(buf, []byte(r.Database), []byte(r.Table)).String()
+	c := d.TableConfig(table).Script
+	if c == nil || c.Script() == nil {
+		return []*sarama.ConsumerMessage{msg}, nil
+	}
+
+	s := c.Script()
+	if ok, err := s.Reload(); err != nil {
+		d.logger.Warn(`reload script`, zap.String("table", table), zap.Error(err))
+	} else if ok {
+		d.logger.Info(`reload script`, zap.String("table", table), zap.String("file", c.File))
+	}
+
+	values, err := s.Run(msg.Value)
+	if err == nil {
+		err = script.Check(values)
+	}
+	if err != nil {
+		switch c.OnError {
+		case script.OnErrorStop:
+			return nil, errors.Wrapf(err, "script %s at offset %d", table, msg.Offset)
+		case script.OnErrorDrop:
+			d.logger.Error(`script, drop`, zap.String("table", table), zap.Int64("offset", msg.Offset), zap.Error(err))
+			return nil, nil
+		}
+		d.logger.Error(`script`, zap.String("table", table), zap.Int64("offset", msg.Offset), zap.Error(err))
+		return []*sarama.ConsumerMessage{msg}, nil
+	}
+
+	msgs := make([]*sarama.ConsumerMessage, 0, len(values))
+	for _, v := range values {
+		m := *msg
+		m.Key, m.Value = script.Key(msg.Key, v), v
+		msgs = append(msgs, &m)
+	}
+	return msgs, nil
+}
+
+// sqlScripted 表有 Script 时保存的是脚本的输出, 可能和 mysql 的表不同, 没有 allowLossy 时拒绝生成 sql
+func (d *timedDump) sqlScripted(database, table string, allowLossy bool) error {
+	buf := &bytes.Buffer{}
+	if d.TableConfig(makeDBTable(buf, []byte(database), []byte(table)).String()).Script != nil && !allowLossy {
+		return badRequest("%s.%s: rows are changed by Script and may not match mysql, set allow lossy to generate sql anyway",
+			database, table)
+	}
+	return nil
+}
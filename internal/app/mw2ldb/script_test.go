@@ -0,0 +1,153 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/offsets"
+	"github.com/helloshiki/maxwell-output/pkg/script"
+)
+
+func TestScriptOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// 拆分到同一张表会在 log 中覆盖, 没有类型或者表名的事件无法写入, 都按出错处理
+	for i, src := range []string{
+		`function process(e) return {e, e} end`,
+		`function process(e) return {} end`,
+		`function process(e) e.type = "upsert" end`,
+		`function process(e) e.table = "" end`,
+	} {
+		file := filepath.Join(dir, fmt.Sprintf("hook%d.lua", i))
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		testOnError(t, file)
+	}
+}
+
+func testOnError(t *testing.T, file string) {
+	for onError, want := range map[string]int{script.OnErrorKeep: 1, script.OnErrorDrop: 0, script.OnErrorStop: -1} {
+		d := newTestDump(t)
+		c := &script.Config{File: file, OnError: onError}
+		if err := c.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		d.TableConfigs["hsn.user_info"] = &TableConfig{Script: c}
+
+		if err := d.Dump(testMsg(1, 1, "insert", 1000, `{"id":1}`, "")); err != nil {
+			t.Fatal(err)
+		}
+		err := d.Dump(nil)
+		if want < 0 {
+			if last, _ := d.LastOffset(); err == nil || last != offsets.Unset {
+				t.Errorf("%s %s: expect error without saving the offset, got %v, %d", file, onError, err, last)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, _, err := d.QueryLog(LogOpt{Database: "hsn", Table: "user_info"})
+		if err != nil || len(r) != want {
+			t.Errorf("%s %s: expect %d log, got %d, %v", file, onError, want, len(r), err)
+		}
+	}
+}
+
+func TestFlashbackScripted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "hook.lua")
+	if err := ioutil.WriteFile(file, []byte(`function process(e) e.data.n = 1 end`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDump(t)
+	c := &script.Config{File: file}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	d.TableConfigs["hsn.user_info"] = &TableConfig{Script: c}
+
+	for _, msg := range []*sarama.ConsumerMessage{
+		testMsg(1, 1, "insert", 1000, `{"id":1}`, ""),
+		testMsg(2, 1, "delete", 1001, `{"id":1}`, ""),
+	} {
+		if err := d.Dump(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Dump(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := FlashbackOpt{Database: "hsn", Table: "user_info", Reveal: true}
+	if err := d.Flashback(opt, &bytes.Buffer{}); err == nil {
+		t.Error("expect error for a table with Script")
+	}
+	if err := d.SQLExport(SQLExportOpt{Database: "hsn", Reveal: true}, &bytes.Buffer{}); err == nil {
+		t.Error("expect sqlexport error for a table with Script")
+	}
+
+	opt.AllowLossy = true
+	buf := &bytes.Buffer{}
+	if err := d.Flashback(opt, buf); err != nil || !strings.Contains(buf.String(), "INSERT") {
+		t.Errorf("got %s, %v", buf.String(), err)
+	}
+}
+
+func TestQueryTxSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// 拆分到两张表的事件共用 offset, 事务中两条都要能查到
+	file := filepath.Join(dir, "hook.lua")
+	src := `function process(e)
+  local copy = {database = e.database, table = "user_audit", type = e.type, ts = e.ts, xid = e.xid, commit = e.commit, data = e.data}
+  return {e, copy}
+end`
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDump(t)
+	c := &script.Config{File: file}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	d.TableConfigs["hsn.user_info"] = &TableConfig{Script: c}
+
+	if err := d.Dump(testMsg(7, 1, "insert", 1000, `{"id":1}`, "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Dump(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.QueryTx(TxOpt{Xid: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 2 || logKeyTable([]byte(r[0].(record).K)) == logKeyTable([]byte(r[1].(record).K)) {
+		t.Errorf("expect events of both tables, got %v", r)
+	}
+}
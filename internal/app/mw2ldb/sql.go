@@ -0,0 +1,373 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+)
+
+type sqlEvent struct {
+	Type     string                     `json:"type"`
+	Ts       int64                      `json:"ts"`
+	Xid      int64                      `json:"xid"`
+	Commit   bool                       `json:"commit"`
+	Position string                     `json:"position"`
+	Data     map[string]json.RawMessage `json:"data"`
+	Old      map[string]json.RawMessage `json:"old"`
+}
+
+const (
+	DialectMySQL  = "mysql"
+	DialectSQLite = "sqlite"
+)
+
+type sqlWriter struct {
+	database string
+	table    string
+	def      *schema.Def
+	dialect  string
+	upsert   bool
+	redact   redactFunc
+}
+
+func (s *sqlWriter) name() string {
+	if s.dialect == DialectSQLite {
+		return quoteName(s.table, s.dialect)
+	}
+	return quoteName(s.database, s.dialect) + "." + quoteName(s.table, s.dialect)
+}
+
+func (s *sqlWriter) value(column string, v json.RawMessage) string {
+	return quoteValue(v, s.def.ColumnType(column), s.dialect)
+}
+
+func (s *sqlWriter) limit1() string {
+	if s.dialect == DialectSQLite { // sqlite 默认不支持 UPDATE/DELETE ... LIMIT
+		return ";"
+	}
+	return " LIMIT 1;"
+}
+
+// where 优先使用主键, 没有主键时使用全部列
+func (s *sqlWriter) where(buf *bytes.Buffer, data map[string]json.RawMessage) {
+	names := []string(nil)
+	if s.def != nil {
+		for _, k := range s.def.PrimaryKey {
+			if _, ok := data[k]; !ok {
+				names = nil
+				break
+			}
+			names = append(names, k)
+		}
+	}
+
+	if len(names) == 0 {
+		names = s.def.ColumnNames(data)
+	}
+
+	buf.WriteString(" WHERE ")
+	for i, k := range names {
+		if i > 0 {
+			buf.WriteString(" AND ")
+		}
+
+		buf.WriteString(quoteName(k, s.dialect))
+		if v := data[k]; len(v) == 0 || string(v) == "null" {
+			buf.WriteString(" IS NULL")
+			continue
+		}
+
+		buf.WriteString("=")
+		buf.WriteString(s.value(k, data[k]))
+	}
+}
+
+func (s *sqlWriter) insert(buf *bytes.Buffer, data map[string]json.RawMessage) {
+	names := s.def.ColumnNames(data)
+
+	switch {
+	case s.upsert && s.dialect == DialectSQLite:
+		buf.WriteString("INSERT OR REPLACE INTO ")
+	default:
+		buf.WriteString("INSERT INTO ")
+	}
+
+	buf.WriteString(s.name())
+	buf.WriteString("(")
+	for i, k := range names {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(quoteName(k, s.dialect))
+	}
+
+	buf.WriteString(") VALUES (")
+	for i, k := range names {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(s.value(k, data[k]))
+	}
+	buf.WriteString(")")
+
+	if s.upsert && s.dialect != DialectSQLite {
+		buf.WriteString(" ON DUPLICATE KEY UPDATE ")
+		for i, k := range names {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			name := quoteName(k, s.dialect)
+			buf.WriteString(name + "=VALUES(" + name + ")")
+		}
+	}
+	buf.WriteString(";")
+}
+
+func (s *sqlWriter) update(buf *bytes.Buffer, set, data map[string]json.RawMessage) {
+	buf.WriteString("UPDATE ")
+	buf.WriteString(s.name())
+	buf.WriteString(" SET ")
+	for i, k := range s.def.ColumnNames(set) {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(quoteName(k, s.dialect))
+		buf.WriteString("=")
+		buf.WriteString(s.value(k, set[k]))
+	}
+
+	s.where(buf, data)
+	buf.WriteString(s.limit1())
+}
+
+func (s *sqlWriter) delete(buf *bytes.Buffer, data map[string]json.RawMessage) {
+	buf.WriteString("DELETE FROM ")
+	buf.WriteString(s.name())
+	s.where(buf, data)
+	buf.WriteString(s.limit1())
+}
+
+// flashback 生成撤销 e 的语句, 非 dml 消息返回 false
+func (s *sqlWriter) flashback(buf *bytes.Buffer, e *sqlEvent) bool {
+	s.redact.apply(e.Data)
+	s.redact.apply(e.Old)
+
+	switch e.Type {
+	case "insert", "bootstrap-insert":
+		s.delete(buf, e.Data)
+	case "update":
+		if len(e.Old) == 0 {
+			return false
+		}
+		s.update(buf, e.Old, e.Data)
+	case "delete":
+		s.insert(buf, e.Data)
+	default:
+		return false
+	}
+	return true
+}
+
+// replay 生成重放 e 的语句, 非 dml 消息返回 false
+func (s *sqlWriter) replay(buf *bytes.Buffer, e *sqlEvent) bool {
+	s.redact.apply(e.Data)
+	s.redact.apply(e.Old)
+
+	switch e.Type {
+	case "insert", "bootstrap-insert":
+		s.insert(buf, e.Data)
+	case "update":
+		if s.upsert {
+			s.insert(buf, e.Data)
+			break
+		}
+
+		if len(e.Old) == 0 {
+			return false
+		}
+
+		// 主键也可能被修改, 用修改前的行定位
+		before := make(map[string]json.RawMessage, len(e.Data))
+		set := make(map[string]json.RawMessage, len(e.Old))
+		for k, v := range e.Data {
+			before[k] = v
+		}
+		for k, v := range e.Old {
+			before[k] = v
+			set[k] = e.Data[k]
+		}
+		s.update(buf, set, before)
+	case "delete":
+		s.delete(buf, e.Data)
+	default:
+		return false
+	}
+	return true
+}
+
+func quoteName(name, dialect string) string {
+	if dialect == DialectSQLite {
+		return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+	}
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func isNumericType(typ string) bool {
+	switch strings.ToLower(typ) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint",
+		"decimal", "numeric", "float", "double", "real", "bit", "year":
+		return true
+	}
+	return false
+}
+
+func isBinaryType(typ string) bool {
+	switch strings.ToLower(typ) {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return true
+	}
+	return false
+}
+
+// quoteValue 把 maxwell 输出的 json 值转成 sql 字面量, typ 为空时按 json 类型推断
+func quoteValue(v json.RawMessage, typ, dialect string) string {
+	if len(v) == 0 || string(v) == "null" {
+		return "NULL"
+	}
+
+	switch v[0] {
+	case '"':
+		s := unquoteBytes(v)
+		if isBinaryType(typ) { // maxwell 以 base64 输出二进制列
+			if dialect == DialectSQLite {
+				bs, _ := base64.StdEncoding.DecodeString(s)
+				return "X'" + hex.EncodeToString(bs) + "'"
+			}
+			return "FROM_BASE64(" + quoteString(s, dialect) + ")"
+		}
+		return quoteString(s, dialect)
+	case 't':
+		return "1"
+	case 'f':
+		return "0"
+	case '{', '[': // json 列
+		buf := &bytes.Buffer{}
+		_ = json.Compact(buf, v)
+		return quoteString(buf.String(), dialect)
+	}
+
+	if len(typ) > 0 && !isNumericType(typ) {
+		return quoteString(string(v), dialect)
+	}
+	return string(v)
+}
+
+// unquoteBytes 解码 json 字符串, 和 json.Unmarshal 不同, 不合法的 utf-8 字节原样保留而不是替换成 U+FFFD
+func unquoteBytes(v json.RawMessage) string {
+	if len(v) < 2 {
+		return ""
+	}
+
+	v = v[1 : len(v)-1]
+	buf := make([]byte, 0, len(v))
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' || i+1 >= len(v) {
+			buf = append(buf, v[i])
+			continue
+		}
+
+		i++
+		switch v[i] {
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r, n := unquoteRune(v[i+1:])
+			buf = append(buf, string(r)...)
+			i += n
+		default: // " \ /
+			buf = append(buf, v[i])
+		}
+	}
+	return string(buf)
+}
+
+// unquoteRune 解码 \u 之后的 4 位十六进制, 包括 utf-16 代理对, 返回字符和使用的字节数
+func unquoteRune(v []byte) (rune, int) {
+	hex4 := func(b []byte) rune {
+		if len(b) < 4 {
+			return -1
+		}
+		n, err := strconv.ParseUint(string(b[:4]), 16, 16)
+		if err != nil {
+			return -1
+		}
+		return rune(n)
+	}
+
+	r := hex4(v)
+	if r < 0 {
+		return utf8.RuneError, 0
+	}
+	if utf16.IsSurrogate(r) && len(v) >= 10 && v[4] == '\\' && v[5] == 'u' {
+		if r2 := hex4(v[6:]); r2 >= 0 {
+			if dec := utf16.DecodeRune(r, r2); dec != utf8.RuneError {
+				return dec, 10
+			}
+		}
+	}
+	return r, 4
+}
+
+// quoteString 按字节转义, 不是合法 utf-8 的值 (varbinary, latin1 等) 输出十六进制字面量, 保持原来的字节
+func quoteString(s, dialect string) string {
+	if !utf8.ValidString(s) {
+		return "X'" + hex.EncodeToString([]byte(s)) + "'"
+	}
+
+	if dialect == DialectSQLite { // sqlite 没有反斜杠转义
+		return "'" + strings.Replace(s, "'", "''", -1) + "'"
+	}
+
+	var builder strings.Builder
+	builder.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			builder.WriteString(`\0`)
+		case '\'':
+			builder.WriteString(`\'`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case 0x1a:
+			builder.WriteString(`\Z`)
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	builder.WriteByte('\'')
+	return builder.String()
+}
+
+func sqlComment(buf *bytes.Buffer, offset int64, e *sqlEvent) {
+	buf.WriteString(fmt.Sprintf(" -- offset %d, ts %d, position %s\n", offset, e.Ts, e.Position))
+}
@@ -0,0 +1,161 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/normalize"
+	"github.com/helloshiki/maxwell-output/pkg/redact"
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+	"github.com/helloshiki/maxwell-output/pkg/transform"
+)
+
+func TestQuoteValue(t *testing.T) {
+	for _, c := range []struct {
+		v, typ, want string
+	}{
+		{`null`, "int", `NULL`},
+		{`177`, "int", `177`},
+		{`0E-8`, "decimal", `0E-8`},
+		{`177`, "varchar", `'177'`},
+		{`"it's"`, "", `'it\'s'`},
+		{`"AQI="`, "blob", `FROM_BASE64('AQI=')`},
+		{`{"a": 1}`, "json", `'{"a":1}'`},
+		{`"a\"b\\c\n\u00e9\ud83d\ude00"`, "varchar", `'a"b\\c\né😀'`},
+		{"\"a\xffb\"", "char", `X'61ff62'`}, // 不是合法 utf-8 时保留原来的字节
+	} {
+		if got := quoteValue(json.RawMessage(c.v), c.typ, DialectMySQL); got != c.want {
+			t.Errorf("%s %s: got %s, want %s", c.v, c.typ, got, c.want)
+		}
+	}
+}
+
+func TestFlashback(t *testing.T) {
+	var def schema.Def
+	_ = json.Unmarshal([]byte(`{"primary-key":["id"],"columns":[{"type":"int","name":"id"},{"type":"char","name":"mail"}]}`), &def)
+	s := &sqlWriter{database: "hsn", table: "user_info", def: &def}
+
+	var e sqlEvent
+	_ = json.Unmarshal([]byte(`{"type":"update","data":{"id":1,"mail":"b"},"old":{"mail":"a"}}`), &e)
+
+	buf := &bytes.Buffer{}
+	s.flashback(buf, &e)
+	if want := "UPDATE `hsn`.`user_info` SET `mail`='a' WHERE `id`=1 LIMIT 1;"; buf.String() != want {
+		t.Errorf("got %s, want %s", buf.String(), want)
+	}
+}
+
+func TestFlashbackRedacted(t *testing.T) {
+	d := newTestDump(t)
+	d.redactor, _ = redact.New(&redact.Keys{HashKey: "k", EncryptKey: strings.Repeat("ab", 16)})
+	d.TableConfigs["hsn.user_info"] = &TableConfig{Redact: []*redact.Rule{{Column: "card", Action: redact.ActionEncrypt}}}
+
+	for _, msg := range []*sarama.ConsumerMessage{
+		testMsg(1, 1, "insert", 1000, `{"id":1,"card":"110"}`, ""),
+		testMsg(2, 1, "update", 1001, `{"id":1,"card":"220"}`, `{"card":"110"}`),
+	} {
+		if err := d.Dump(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Dump(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 生成 sql 时解密
+	opt := FlashbackOpt{Database: "hsn", Table: "user_info", Reveal: true}
+	buf := &bytes.Buffer{}
+	if err := d.Flashback(opt, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "'110'") || strings.Contains(buf.String(), "enc:") {
+		t.Errorf("expect decrypted values, got %s", buf.String())
+	}
+
+	// 无法解密或者有 hash 规则时需要 AllowLossy
+	opt.Reveal = false
+	if err := d.Flashback(opt, &bytes.Buffer{}); err == nil {
+		t.Error("expect error without reveal")
+	}
+
+	opt.Reveal = true
+	d.TableConfigs["hsn.user_info"].Redact = append(d.TableConfigs["hsn.user_info"].Redact, &redact.Rule{Column: "id", Action: redact.ActionHash})
+	if err := d.SQLExport(SQLExportOpt{Database: "hsn", Table: "user_info", Reveal: true}, &bytes.Buffer{}); err == nil {
+		t.Error("expect error for hashed column")
+	}
+
+	opt.AllowLossy = true
+	if err := d.Flashback(opt, &bytes.Buffer{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlashbackNormalized(t *testing.T) {
+	d := newTestDump(t)
+	for _, msg := range []*sarama.ConsumerMessage{
+		testMsg(1, 1, "insert", 1000, `{"id":1,"price":0.50}`, ""),
+		testMsg(2, 1, "update", 1001, `{"id":1,"price":0.60}`, `{"price":0.50}`),
+	} {
+		if err := d.Dump(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Dump(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for c, ok := range map[*normalize.Config]bool{
+		{Decimal: normalize.DecimalString}: true,
+		{Decimal: normalize.DecimalScaled}: false,
+		{FlattenJSON: true}:                false,
+	} {
+		d.TableConfigs["hsn.user_info"] = &TableConfig{Normalize: c}
+		if err := d.Flashback(FlashbackOpt{Database: "hsn", Table: "user_info"}, &bytes.Buffer{}); ok != (err == nil) {
+			t.Errorf("%+v: flashback got %v", c, err)
+		}
+		if err := d.SQLExport(SQLExportOpt{Database: "hsn"}, &bytes.Buffer{}); ok != (err == nil) {
+			t.Errorf("%+v: sqlexport got %v", c, err)
+		}
+	}
+}
+
+func TestFlashbackTransformed(t *testing.T) {
+	d := newTestDump(t)
+	d.TableConfigs["hsn.user_info"] = &TableConfig{Transforms: []*transform.Step{{Op: transform.OpRename, From: "parend_id", Column: "parent_id"}}}
+	if err := transform.Validate(d.TableConfigs["hsn.user_info"].Transforms); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []*sarama.ConsumerMessage{
+		testMsg(1, 1, "insert", 1000, `{"id":1,"parend_id":1}`, ""),
+		testMsg(2, 1, "update", 1001, `{"id":1,"parend_id":2}`, `{"parend_id":1}`),
+	} {
+		if err := d.Dump(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Dump(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := FlashbackOpt{Database: "hsn", Table: "user_info", Reveal: true}
+	if err := d.Flashback(opt, &bytes.Buffer{}); err == nil {
+		t.Error("expect error for renamed columns")
+	}
+	if err := d.SQLExport(SQLExportOpt{Database: "hsn", Reveal: true}, &bytes.Buffer{}); err == nil {
+		t.Error("expect sqlexport error for renamed columns")
+	}
+
+	// 显式允许时生成的 sql 使用保存的列名
+	opt.AllowLossy = true
+	buf := &bytes.Buffer{}
+	if err := d.Flashback(opt, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "`parent_id`=1") {
+		t.Errorf("got %s", buf.String())
+	}
+}
@@ -0,0 +1,129 @@
+package mw2ldb
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// log: 键中的时间是 UTC, 查询参数支持以下格式:
+//   20060102150405       在 tz 时区, 默认服务器本地时区, 兼容旧的参数
+//   2006-01-02T15:04:05Z RFC3339, 自带时区
+//   1570000000           unix 秒, 9 或 10 位
+//   1570000000000        unix 毫秒, 13 位
+//   now, -1h, -30m, -7d  相对当前时间
+
+// loadLocation 支持 IANA 名字 (Asia/Shanghai), UTC 和 +08:00 形式的偏移
+func loadLocation(tz string) (*time.Location, error) {
+	switch tz {
+	case "":
+		return time.Local, nil
+	case "UTC", "Z", "utc":
+		return time.UTC, nil
+	}
+
+	if tz[0] == '+' || tz[0] == '-' {
+		t, err := time.Parse("-07:00", tz)
+		if err != nil {
+			if t, err = time.Parse("-0700", tz); err != nil {
+				return nil, badRequest("bad tz %s", tz)
+			}
+		}
+
+		_, offset := t.Zone()
+		return time.FixedZone(tz, offset), nil
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, badRequest("bad tz %s", tz)
+	}
+	return loc, nil
+}
+
+func parseTime(s, tz string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		d, err := parseDuration(s)
+		if err != nil {
+			return time.Time{}, badRequest("bad time %s", s)
+		}
+		return now.Add(d), nil
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		switch {
+		case len(s) == len(TimestampFmt):
+			loc, err := loadLocation(tz)
+			if err != nil {
+				return time.Time{}, err
+			}
+
+			t, err := time.ParseInLocation(TimestampFmt, s, loc)
+			if err != nil {
+				return time.Time{}, badRequest("bad time %s", s)
+			}
+			return t, nil
+		case len(s) == 13:
+			return time.Unix(n/1000, n%1000*int64(time.Millisecond)), nil
+		case len(s) == 9 || len(s) == 10:
+			return time.Unix(n, 0), nil
+		default:
+			return time.Time{}, badRequest("bad time %s", s)
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, badRequest("bad time %s", s)
+	}
+	return t, nil
+}
+
+// parseDuration 在 time.ParseDuration 的基础上支持天: -7d
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// logTime 把时间参数转换成 log: 键中的 UTC 时间, s 为空时返回 def
+func logTime(s, tz, def string, now time.Time) (string, error) {
+	if len(s) == 0 {
+		return def, nil
+	}
+
+	t, err := parseTime(s, tz, now)
+	if err != nil {
+		return "", err
+	}
+
+	t = t.UTC()
+	if t.Year() < 1970 || t.Year() > 9999 {
+		return "", badRequest("bad time %s", s)
+	}
+	return t.Format(TimestampFmt), nil
+}
+
+// logRange 把 [begin, end) 转换成 log: 键中的时间范围, 为空时不限制
+func logRange(begin, end, tz string) (string, string, error) {
+	now := time.Now()
+	b, err := logTime(begin, tz, TimestampMin, now)
+	if err != nil {
+		return "", "", err
+	}
+
+	e, err := logTime(end, tz, TimestampMax, now)
+	if err != nil {
+		return "", "", err
+	}
+	return b, e, nil
+}
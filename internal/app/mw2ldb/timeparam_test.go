@@ -0,0 +1,42 @@
+package mw2ldb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogTime(t *testing.T) {
+	now := time.Date(2019, 10, 10, 4, 0, 0, 0, time.UTC)
+	cases := []struct {
+		s, tz  string
+		expect string
+	}{
+		{"", "", TimestampMin},
+		{"20191010120000", "+08:00", "20191010040000"},
+		{"20191010120000", "Asia/Shanghai", "20191010040000"},
+		{"20191010040000", "UTC", "20191010040000"},
+		{"2019-10-10T12:00:00+08:00", "", "20191010040000"},
+		{"1570680000", "", "20191010040000"},
+		{"1570680000999", "", "20191010040000"},
+		{"now", "", "20191010040000"},
+		{"-1h", "", "20191010030000"},
+		{"-2d", "", "20191008040000"},
+	}
+
+	for _, c := range cases {
+		s, err := logTime(c.s, c.tz, TimestampMin, now)
+		if err != nil || s != c.expect {
+			t.Errorf("%s %s: expect %s, got %s %v", c.s, c.tz, c.expect, s, err)
+		}
+	}
+
+	for _, s := range []string{"2019", "yesterday", "-1y", "2019-10-10"} {
+		if _, err := logTime(s, "", "", now); err == nil {
+			t.Errorf("%s: expect error", s)
+		}
+	}
+
+	if _, err := logTime("20191010120000", "Mars/Base", "", now); err == nil {
+		t.Error("expect bad tz")
+	}
+}
@@ -0,0 +1,40 @@
+package mw2ldb
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+	"github.com/helloshiki/maxwell-output/pkg/transform"
+)
+
+// transformMsg 按 Transforms 配置处理消息中的 data 和 old, 在 normalizeMsg 之后, scriptMsg 之前调用
+func (d *timedDump) transformMsg(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	var r struct {
+		Database string `json:"database"`
+		Table    string `json:"table"`
+	}
+	if err := json.Unmarshal(msg.Value, &r); err != nil {
+		return msg
+	}
+
+	buf := &bytes.Buffer{}
+	steps := d.TableConfig(makeDBTable(buf, []byte(r.Database), []byte(r.Table)).String()).Transforms
+	if len(steps) == 0 {
+		return msg
+	}
+
+	m := *msg
+	m.Value = transform.Message(steps, msg.Value)
+	return &m
+}
+
+// sqlTransformed 表有 Transforms 时保存的列名和值和 mysql 不同 (改名, 删除, 派生的列), 没有 allowLossy 时拒绝生成 sql
+func (d *timedDump) sqlTransformed(database, table string, allowLossy bool) error {
+	buf := &bytes.Buffer{}
+	if len(d.TableConfig(makeDBTable(buf, []byte(database), []byte(table)).String()).Transforms) > 0 && !allowLossy {
+		return badRequest("%s.%s: columns are changed by Transforms and may not match mysql, set allow lossy to generate sql anyway",
+			database, table)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+// Client 是一个调用方, 可以用静态 token, 或者客户端证书 (CN 等于 Name) 认证
+type Client struct {
+	Name    string   `mapstructure:"Name" json:"name"`
+	Token   string   `mapstructure:"Token" json:"-"`         // optional
+	Scopes  []string `mapstructure:"Scopes" json:"scopes"`   // db.table, db.* 或 *
+	Admin   bool     `mapstructure:"Admin" json:"admin"`     // 允许访问管理接口
+	Decrypt bool     `mapstructure:"Decrypt" json:"decrypt"` // 查询时解密 encrypt 的列
+	Exp     int64    `mapstructure:"-" json:"exp,omitempty"` // 只用于 hmac token
+}
+
+// Allow 判断是否可以访问 database.table
+func (c *Client) Allow(database, table string) bool {
+	database, table = strings.ToLower(database), strings.ToLower(table)
+	for _, s := range c.Scopes {
+		s = strings.ToLower(s)
+		if s == "*" || s == database+".*" || s == database+"."+table {
+			return true
+		}
+	}
+	return false
+}
+
+// Config 在 [Misc.Auth] 中配置, 没有配置 Clients 和 HMACSecret 时不认证
+type Config struct {
+	Clients    []*Client `mapstructure:"Clients"`
+	HMACSecret string    `mapstructure:"HMACSecret"` // optional, 用 Sign 签发的 token
+
+	CertFile          string `mapstructure:"CertFile"`          // optional, 开启 https
+	KeyFile           string `mapstructure:"KeyFile"`           // optional
+	ClientCAFile      string `mapstructure:"ClientCAFile"`      // optional, 校验客户端证书
+	RequireClientCert bool   `mapstructure:"RequireClientCert"` // optional, 没有客户端证书时拒绝连接
+}
+
+type Authenticator struct {
+	c *Config
+}
+
+// New 返回 nil 表示不认证
+func New(c *Config) *Authenticator {
+	if c == nil || (len(c.Clients) == 0 && len(c.HMACSecret) == 0) {
+		return nil
+	}
+	return &Authenticator{c: c}
+}
+
+// Authenticate 依次尝试客户端证书, Authorization: Bearer <token> 和 access_token 参数 (EventSource 不能设置请求头)
+func (a *Authenticator) Authenticate(ctx *fasthttp.RequestCtx) (*Client, error) {
+	if c := a.AuthenticateCert(ctx.TLSConnectionState()); c != nil {
+		return c, nil
+	}
+
+	token := string(ctx.QueryArgs().Peek("access_token"))
+	if t := BearerToken(string(ctx.Request.Header.Peek("Authorization"))); len(t) > 0 {
+		token = t
+	}
+
+	return a.AuthenticateToken(token)
+}
+
+// LogArgs 返回用于日志的请求参数, access_token 被替换掉
+func LogArgs(args *fasthttp.Args) string {
+	if !args.Has("access_token") {
+		return args.String()
+	}
+
+	var a fasthttp.Args
+	args.CopyTo(&a)
+	a.Set("access_token", "REDACTED")
+	return a.String()
+}
+
+// AuthenticateCert 返回和客户端证书 CN 同名的 Client, 没有时返回 nil
+func (a *Authenticator) AuthenticateCert(state *tls.ConnectionState) *Client {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+
+	cn := state.PeerCertificates[0].Subject.CommonName
+	for _, c := range a.c.Clients {
+		if c.Name == cn {
+			return c
+		}
+	}
+	return nil
+}
+
+// AuthenticateToken 校验静态 token 或者 hmac token
+func (a *Authenticator) AuthenticateToken(token string) (*Client, error) {
+	if len(token) == 0 {
+		return nil, ErrUnauthorized
+	}
+
+	for _, c := range a.c.Clients {
+		if len(c.Token) > 0 && subtle.ConstantTimeCompare([]byte(c.Token), []byte(token)) == 1 {
+			return c, nil
+		}
+	}
+
+	if len(a.c.HMACSecret) > 0 {
+		if c, err := Verify(a.c.HMACSecret, token); err == nil {
+			return c, nil
+		}
+	}
+
+	return nil, ErrUnauthorized
+}
+
+// BearerToken 从 Authorization 头中取出 token, 不是 Bearer 时返回空
+func BearerToken(h string) string {
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[len("Bearer "):])
+}
+
+// Sign 签发 token: base64url(json) + "." + base64url(hmac-sha256), exp 为零值时不过期
+func Sign(secret string, c *Client, exp time.Time) string {
+	cc := *c
+	if !exp.IsZero() {
+		cc.Exp = exp.Unix()
+	}
+	bs, _ := json.Marshal(&cc)
+	payload := base64.RawURLEncoding.EncodeToString(bs)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac(secret, payload))
+}
+
+func Verify(secret, token string) (*Client, error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return nil, ErrUnauthorized
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(sig, mac(secret, token[:i])) {
+		return nil, ErrUnauthorized
+	}
+
+	bs, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	var c Client
+	if err := json.Unmarshal(bs, &c); err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	if c.Exp > 0 && time.Now().Unix() > c.Exp {
+		return nil, errors.New("token expired")
+	}
+	return &c, nil
+}
+
+func mac(secret, payload string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// Listen 配置了证书时监听 https, 否则监听 http
+func Listen(c *Config, addr string) (net.Listener, error) {
+	cfg, err := TLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg == nil {
+		return net.Listen("tcp4", addr)
+	}
+
+	return tls.Listen("tcp4", addr, cfg)
+}
+
+// TLSConfig 没有配置证书时返回 nil
+func TLSConfig(c *Config) (*tls.Config, error) {
+	if c == nil || len(c.CertFile) == 0 {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if len(c.ClientCAFile) > 0 {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate in %s", c.ClientCAFile)
+		}
+
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestToken(t *testing.T) {
+	c := &Client{Name: "app", Scopes: []string{"hsn.*", "crm.Orders"}}
+
+	token := Sign("secret", c, time.Now().Add(time.Hour))
+	got, err := Verify("secret", token)
+	if err != nil || got.Name != "app" || got.Admin {
+		t.Fatal(got, err)
+	}
+
+	if _, err := Verify("other", token); err == nil {
+		t.Error("expect bad signature")
+	}
+
+	if _, err := Verify("secret", Sign("secret", c, time.Now().Add(-time.Second))); err == nil {
+		t.Error("expect expired")
+	}
+
+	if _, err := Verify("secret", Sign("secret", c, time.Time{})); err != nil {
+		t.Error(err)
+	}
+
+	for _, s := range []struct {
+		database, table string
+		expect          bool
+	}{
+		{"hsn", "user_info", true},
+		{"crm", "orders", true},
+		{"crm", "users", false},
+		{"", "", false},
+	} {
+		if got.Allow(s.database, s.table) != s.expect {
+			t.Errorf("%s.%s: expect %v", s.database, s.table, s.expect)
+		}
+	}
+}
+
+func TestLogArgs(t *testing.T) {
+	var args fasthttp.Args
+	args.Parse("db=hsn&access_token=secret&tb=user_info")
+	if s := LogArgs(&args); s != "db=hsn&access_token=REDACTED&tb=user_info" {
+		t.Errorf("bad args: %s", s)
+	}
+	if s := args.String(); s != "db=hsn&access_token=secret&tb=user_info" {
+		t.Errorf("expect the request args untouched, got %s", s)
+	}
+}
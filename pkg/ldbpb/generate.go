@@ -0,0 +1,4 @@
+// Package ldbpb 是 mw2ldb grpc 接口的生成代码
+package ldbpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ldb.proto
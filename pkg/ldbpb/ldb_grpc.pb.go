@@ -0,0 +1,259 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: ldb.proto
+
+// mw2ldb 的 grpc 查询接口, 和 /v1 http 接口一一对应 (见 api/openapi.yaml)
+// 重新生成: go generate ./pkg/ldbpb
+
+package ldbpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Ldb_GetRow_FullMethodName       = "/mw2ldb.v1.Ldb/GetRow"
+	Ldb_ScanRows_FullMethodName     = "/mw2ldb.v1.Ldb/ScanRows"
+	Ldb_ScanLog_FullMethodName      = "/mw2ldb.v1.Ldb/ScanLog"
+	Ldb_WatchChanges_FullMethodName = "/mw2ldb.v1.Ldb/WatchChanges"
+)
+
+// LdbClient is the client API for Ldb service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LdbClient interface {
+	// 单行的当前值, 指定 at 时返回该时刻的值, 不存在时返回 NOT_FOUND
+	GetRow(ctx context.Context, in *GetRowRequest, opts ...grpc.CallOption) (*Row, error)
+	// 当前行, 按主键排序
+	ScanRows(ctx context.Context, in *ScanRowsRequest, opts ...grpc.CallOption) (*ScanRowsResponse, error)
+	// 变更日志, 按时间排序
+	ScanLog(ctx context.Context, in *ScanLogRequest, opts ...grpc.CallOption) (*ScanLogResponse, error)
+	// 先回放积压的变更, 再推送新的变更
+	WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (Ldb_WatchChangesClient, error)
+}
+
+type ldbClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLdbClient(cc grpc.ClientConnInterface) LdbClient {
+	return &ldbClient{cc}
+}
+
+func (c *ldbClient) GetRow(ctx context.Context, in *GetRowRequest, opts ...grpc.CallOption) (*Row, error) {
+	out := new(Row)
+	err := c.cc.Invoke(ctx, Ldb_GetRow_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ldbClient) ScanRows(ctx context.Context, in *ScanRowsRequest, opts ...grpc.CallOption) (*ScanRowsResponse, error) {
+	out := new(ScanRowsResponse)
+	err := c.cc.Invoke(ctx, Ldb_ScanRows_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ldbClient) ScanLog(ctx context.Context, in *ScanLogRequest, opts ...grpc.CallOption) (*ScanLogResponse, error) {
+	out := new(ScanLogResponse)
+	err := c.cc.Invoke(ctx, Ldb_ScanLog_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ldbClient) WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (Ldb_WatchChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Ldb_ServiceDesc.Streams[0], Ldb_WatchChanges_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ldbWatchChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Ldb_WatchChangesClient interface {
+	Recv() (*Change, error)
+	grpc.ClientStream
+}
+
+type ldbWatchChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *ldbWatchChangesClient) Recv() (*Change, error) {
+	m := new(Change)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LdbServer is the server API for Ldb service.
+// All implementations must embed UnimplementedLdbServer
+// for forward compatibility
+type LdbServer interface {
+	// 单行的当前值, 指定 at 时返回该时刻的值, 不存在时返回 NOT_FOUND
+	GetRow(context.Context, *GetRowRequest) (*Row, error)
+	// 当前行, 按主键排序
+	ScanRows(context.Context, *ScanRowsRequest) (*ScanRowsResponse, error)
+	// 变更日志, 按时间排序
+	ScanLog(context.Context, *ScanLogRequest) (*ScanLogResponse, error)
+	// 先回放积压的变更, 再推送新的变更
+	WatchChanges(*WatchChangesRequest, Ldb_WatchChangesServer) error
+	mustEmbedUnimplementedLdbServer()
+}
+
+// UnimplementedLdbServer must be embedded to have forward compatible implementations.
+type UnimplementedLdbServer struct {
+}
+
+func (UnimplementedLdbServer) GetRow(context.Context, *GetRowRequest) (*Row, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRow not implemented")
+}
+func (UnimplementedLdbServer) ScanRows(context.Context, *ScanRowsRequest) (*ScanRowsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ScanRows not implemented")
+}
+func (UnimplementedLdbServer) ScanLog(context.Context, *ScanLogRequest) (*ScanLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ScanLog not implemented")
+}
+func (UnimplementedLdbServer) WatchChanges(*WatchChangesRequest, Ldb_WatchChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchChanges not implemented")
+}
+func (UnimplementedLdbServer) mustEmbedUnimplementedLdbServer() {}
+
+// UnsafeLdbServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LdbServer will
+// result in compilation errors.
+type UnsafeLdbServer interface {
+	mustEmbedUnimplementedLdbServer()
+}
+
+func RegisterLdbServer(s grpc.ServiceRegistrar, srv LdbServer) {
+	s.RegisterService(&Ldb_ServiceDesc, srv)
+}
+
+func _Ldb_GetRow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LdbServer).GetRow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Ldb_GetRow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LdbServer).GetRow(ctx, req.(*GetRowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ldb_ScanRows_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRowsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LdbServer).ScanRows(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Ldb_ScanRows_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LdbServer).ScanRows(ctx, req.(*ScanRowsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ldb_ScanLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LdbServer).ScanLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Ldb_ScanLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LdbServer).ScanLog(ctx, req.(*ScanLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ldb_WatchChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LdbServer).WatchChanges(m, &ldbWatchChangesServer{stream})
+}
+
+type Ldb_WatchChangesServer interface {
+	Send(*Change) error
+	grpc.ServerStream
+}
+
+type ldbWatchChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *ldbWatchChangesServer) Send(m *Change) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Ldb_ServiceDesc is the grpc.ServiceDesc for Ldb service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Ldb_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mw2ldb.v1.Ldb",
+	HandlerType: (*LdbServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRow",
+			Handler:    _Ldb_GetRow_Handler,
+		},
+		{
+			MethodName: "ScanRows",
+			Handler:    _Ldb_ScanRows_Handler,
+		},
+		{
+			MethodName: "ScanLog",
+			Handler:    _Ldb_ScanLog_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchChanges",
+			Handler:       _Ldb_WatchChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "ldb.proto",
+}
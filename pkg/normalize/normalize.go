@@ -0,0 +1,235 @@
+package normalize
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+	"github.com/pkg/errors"
+)
+
+const (
+	DecimalString = "string" // 精确的十进制字符串, 0E-8 -> "0.00000000"
+	DecimalScaled = "scaled" // 乘以 10^scale 的整数, 0.5 (scale 2) -> 50
+
+	BinaryBase64 = "base64" // maxwell 的输出, 不处理
+	BinaryHex    = "hex"
+)
+
+// datetime 输出的格式, 保留非零的小数秒
+const isoFmt = "2006-01-02T15:04:05.999999Z07:00"
+
+// Config 在 [TableConfigs.xxx.Normalize] 中配置. 列的类型来自 table-create/table-alter 记录的表结构,
+// 没有表结构时不处理. 保存的是处理后的值, 不能写回 mysql 时 (见 SQLSafe) 拒绝 flashback 和 sqlexport
+type Config struct {
+	Decimal     string `mapstructure:"Decimal"`     // string, scaled; 为空时不处理
+	SourceTZ    string `mapstructure:"SourceTZ"`    // datetime 列的时区, 例如 Asia/Shanghai; 为空时不处理日期时间
+	FlattenJSON bool   `mapstructure:"FlattenJSON"` // json 列展开成 col.a.b, 数组不展开
+	Binary      string `mapstructure:"Binary"`      // base64 (默认), hex
+
+	loc *time.Location
+}
+
+// Validate 检查配置并加载时区
+func (c *Config) Validate() error {
+	switch c.Decimal {
+	case "", DecimalString, DecimalScaled:
+	default:
+		return errors.Errorf("bad Decimal: %s", c.Decimal)
+	}
+
+	switch c.Binary {
+	case "", BinaryBase64, BinaryHex:
+	default:
+		return errors.Errorf("bad Binary: %s", c.Binary)
+	}
+
+	if len(c.SourceTZ) > 0 {
+		loc, err := time.LoadLocation(c.SourceTZ)
+		if err != nil {
+			return errors.Wrap(err, "bad SourceTZ")
+		}
+		c.loc = loc
+	}
+	return nil
+}
+
+// SQLSafe 判断处理后的值是否仍然可以直接写回 mysql. scaled 的 decimal, 展开的 json,
+// 转换时区的日期时间和 hex 的二进制都改变了原来的值, 返回 false
+func (c *Config) SQLSafe() bool {
+	if c == nil {
+		return true
+	}
+	return c.Decimal != DecimalScaled && !c.FlattenJSON && len(c.SourceTZ) == 0 && c.Binary != BinaryHex
+}
+
+// Apply 按表结构原地处理 data, 无法解析的值保持不变
+func (c *Config) Apply(def *schema.Def, data map[string]json.RawMessage) {
+	if c == nil || def == nil {
+		return
+	}
+
+	for _, col := range def.Columns {
+		v, ok := data[col.Name]
+		if !ok || len(v) == 0 || string(v) == "null" {
+			continue
+		}
+
+		switch typ := strings.ToLower(col.Type); {
+		case typ == "decimal" && len(c.Decimal) > 0:
+			data[col.Name] = c.decimal(v, col.Scale)
+		case (typ == "datetime" || typ == "timestamp") && c.loc != nil:
+			data[col.Name] = c.datetime(v, typ)
+		case typ == "json" && c.FlattenJSON:
+			delete(data, col.Name)
+			flatten(data, col.Name, v)
+		case isBinary(typ) && c.Binary == BinaryHex:
+			data[col.Name] = binaryHex(v)
+		}
+	}
+}
+
+// Message 处理 maxwell 消息中的 data 和 old, 没有配置或者没有表结构时原样返回
+func (c *Config) Message(def *schema.Def, value []byte) []byte {
+	if c == nil || def == nil {
+		return value
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(value, &m); err != nil {
+		return value
+	}
+
+	for _, field := range []string{"data", "old"} {
+		if len(m[field]) == 0 || m[field][0] != '{' {
+			continue
+		}
+
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal(m[field], &data); err != nil {
+			continue
+		}
+
+		c.Apply(def, data)
+		m[field], _ = json.Marshal(data)
+	}
+
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return value
+	}
+	return bs
+}
+
+// decimal 用 big.Rat 精确转换, scale 为 0 时取值本身的小数位数
+func (c *Config) decimal(v json.RawMessage, scale int) json.RawMessage {
+	s := strings.Trim(string(v), `"`)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return v
+	}
+
+	if scale <= 0 {
+		scale = decimalScale(s)
+	}
+
+	if c.Decimal == DecimalScaled {
+		n := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+		if n.IsInt() {
+			return json.RawMessage(n.Num().String())
+		}
+	}
+	return quote(r.FloatString(scale))
+}
+
+// decimalScale 返回 1.50, 0E-8 这样的文本中的小数位数
+func decimalScale(s string) int {
+	s = strings.ToLower(s)
+	exp := 0
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		n, _ := new(big.Int).SetString(strings.TrimPrefix(s[i+1:], "+"), 10)
+		if n != nil {
+			exp = int(n.Int64())
+		}
+		s = s[:i]
+	}
+
+	scale := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		scale = len(s) - i - 1
+	}
+
+	if scale -= exp; scale < 0 {
+		return 0
+	}
+	return scale
+}
+
+// datetime 转成 ISO-8601. maxwell 输出的 datetime 没有时区, 按 SourceTZ 解析; timestamp 是 UTC
+func (c *Config) datetime(v json.RawMessage, typ string) json.RawMessage {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return v
+	}
+
+	loc := c.loc
+	if typ == "timestamp" {
+		loc = time.UTC
+	}
+
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", s, loc)
+	if err != nil { // 0000-00-00 00:00:00 等
+		return v
+	}
+	return quote(t.Format(isoFmt))
+}
+
+// flatten 把 json 列递归展开到 data 中, maxwell 把 json 列作为对象输出, 旧版本是字符串
+func flatten(data map[string]json.RawMessage, field string, v json.RawMessage) {
+	if len(v) > 0 && v[0] == '"' {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil && strings.HasPrefix(s, "{") {
+			v = json.RawMessage(s)
+		}
+	}
+
+	var m map[string]json.RawMessage
+	if len(v) == 0 || v[0] != '{' || json.Unmarshal(v, &m) != nil {
+		data[field] = v
+		return
+	}
+
+	for k, sub := range m {
+		flatten(data, field+"."+k, sub)
+	}
+}
+
+func isBinary(typ string) bool {
+	switch typ {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return true
+	}
+	return false
+}
+
+func binaryHex(v json.RawMessage) json.RawMessage {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return v
+	}
+
+	bs, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return v
+	}
+	return quote(hex.EncodeToString(bs))
+}
+
+func quote(s string) json.RawMessage {
+	bs, _ := json.Marshal(s)
+	return bs
+}
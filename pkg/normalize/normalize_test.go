@@ -0,0 +1,49 @@
+package normalize
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+)
+
+func TestApply(t *testing.T) {
+	var def schema.Def
+	_ = json.Unmarshal([]byte(`{"columns":[{"type":"decimal","name":"balance","scale":8},{"type":"decimal","name":"rate"},`+
+		`{"type":"datetime","name":"create_date"},{"type":"timestamp","name":"update_at"},{"type":"json","name":"ext"},{"type":"varbinary","name":"salt"}]}`), &def)
+
+	c := &Config{Decimal: DecimalString, SourceTZ: "Asia/Shanghai", FlattenJSON: true, Binary: BinaryHex}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var data map[string]json.RawMessage
+	_ = json.Unmarshal([]byte(`{"balance":0E-8,"rate":1.50,"create_date":"2019-09-18 06:48:20","update_at":"2019-09-18 06:48:20.120",`+
+		`"ext":{"a":1,"b":{"c":[1,2]}},"salt":"AQL/"}`), &data)
+	c.Apply(&def, data)
+
+	for k, want := range map[string]string{
+		"balance":     `"0.00000000"`,
+		"rate":        `"1.50"`,
+		"create_date": `"2019-09-18T06:48:20+08:00"`,
+		"update_at":   `"2019-09-18T06:48:20.12Z"`,
+		"ext.a":       `1`,
+		"ext.b.c":     `[1,2]`,
+		"salt":        `"0102ff"`,
+	} {
+		if string(data[k]) != want {
+			t.Errorf("%s: got %s, want %s", k, data[k], want)
+		}
+	}
+
+	if _, ok := data["ext"]; ok {
+		t.Error("expect ext to be flattened")
+	}
+
+	c = &Config{Decimal: DecimalScaled}
+	data = map[string]json.RawMessage{"balance": json.RawMessage(`12.5`), "rate": json.RawMessage(`3E+2`)}
+	c.Apply(&def, data)
+	if string(data["balance"]) != "1250000000" || string(data["rate"]) != "300" {
+		t.Errorf("bad scaled: %s %s", data["balance"], data["rate"])
+	}
+}
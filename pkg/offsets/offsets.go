@@ -0,0 +1,252 @@
+package offsets
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	ModeOffset = "offset"
+	ModeTime   = "time"
+	ModeOldest = "oldest"
+	ModeNewest = "newest"
+)
+
+// Unset 是从未保存过 offset 时 LastOffset 的返回值, 和 rewind 到 0 后保存的 -1 区分
+const Unset = -2
+
+// Rewind 描述一次 offset 重置, 同时作为审计记录保存
+type Rewind struct {
+	Partition int32  `json:"partition"`
+	Mode      string `json:"mode"`
+	Arg       string `json:"arg,omitempty"`
+	Discard   bool   `json:"discard"` // 丢弃还未写入的缓存, 否则先写入, 有未结束的事务时拒绝
+	From      int64  `json:"from"`    // 重置前下一条要消费的 offset
+	To        int64  `json:"to"`      // 重置后下一条要消费的 offset
+	Remote    string `json:"remote,omitempty"`
+	Time      string `json:"time"`
+}
+
+// ParseRewind 解析 /admin/offset 的参数: partition, offset|time|oldest|newest, discard
+func ParseRewind(args *fasthttp.Args) (*Rewind, error) {
+	r := &Rewind{Discard: args.GetBool(`discard`)}
+
+	if args.Has(`partition`) {
+		n, err := args.GetUint(`partition`)
+		if err != nil {
+			return nil, errors.New(`bad partition`)
+		}
+		r.Partition = int32(n)
+	}
+
+	switch {
+	case args.Has(`offset`):
+		r.Mode, r.Arg = ModeOffset, string(args.Peek(`offset`))
+		if _, err := strconv.ParseInt(r.Arg, 10, 64); err != nil {
+			return nil, errors.New(`bad offset`)
+		}
+	case args.Has(`time`):
+		r.Mode, r.Arg = ModeTime, string(args.Peek(`time`))
+		if _, err := time.Parse(time.RFC3339, r.Arg); err != nil {
+			return nil, errors.New(`bad time`)
+		}
+	case args.Has(`oldest`):
+		r.Mode = ModeOldest
+	case args.Has(`newest`):
+		r.Mode = ModeNewest
+	default:
+		return nil, errors.New(`one of offset/time/oldest/newest is required`)
+	}
+
+	return r, nil
+}
+
+// OffsetGetter 是 sarama.Client 中查询 offset 的方法
+type OffsetGetter interface {
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+// ErrOutOfRange 表示指定的 offset 不在 [oldest, newest] 内
+var ErrOutOfRange = errors.New("offset out of range")
+
+// Resolve 把 r 解析成下一条要消费的 offset, 指定的 offset 必须在 [oldest, newest] 内
+func Resolve(client OffsetGetter, topic string, r *Rewind) (int64, error) {
+	switch r.Mode {
+	case ModeOffset:
+		to, err := strconv.ParseInt(r.Arg, 10, 64)
+		if err != nil {
+			return -1, err
+		}
+
+		oldest, err := client.GetOffset(topic, r.Partition, sarama.OffsetOldest)
+		if err != nil {
+			return -1, err
+		}
+
+		newest, err := client.GetOffset(topic, r.Partition, sarama.OffsetNewest)
+		if err != nil {
+			return -1, err
+		}
+
+		if to < oldest || to > newest {
+			return -1, errors.Wrapf(ErrOutOfRange, "%d not in [%d, %d]", to, oldest, newest)
+		}
+		return to, nil
+	case ModeOldest:
+		return client.GetOffset(topic, r.Partition, sarama.OffsetOldest)
+	case ModeNewest:
+		return client.GetOffset(topic, r.Partition, sarama.OffsetNewest)
+	case ModeTime:
+		t, err := time.Parse(time.RFC3339, r.Arg)
+		if err != nil {
+			return -1, err
+		}
+
+		offset, err := client.GetOffset(topic, r.Partition, t.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			return -1, err
+		}
+
+		if offset < 0 { // 晚于最新消息
+			return client.GetOffset(topic, r.Partition, sarama.OffsetNewest)
+		}
+		return offset, nil
+	}
+
+	return -1, errors.Errorf("bad mode: %s", r.Mode)
+}
+
+// Position 是 maxwell 消息中的 binlog 位置, 如 master.000009:9275773
+type Position struct {
+	File string
+	Pos  int64
+}
+
+func ParsePosition(s string) (Position, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i <= 0 {
+		return Position{}, errors.Errorf("bad binlog position: %s", s)
+	}
+
+	pos, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil {
+		return Position{}, errors.Errorf("bad binlog position: %s", s)
+	}
+
+	return Position{File: s[:i], Pos: pos}, nil
+}
+
+// Less 同一实例的 binlog 文件名后缀定长, 可以直接按字符串比较
+func (p Position) Less(o Position) bool {
+	if p.File != o.File {
+		return p.File < o.File
+	}
+	return p.Pos < o.Pos
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Pos)
+}
+
+// ValidateStartFrom 检查 MaxWell.StartFrom: oldest, newest, time:<RFC3339>, binlog:<file>:<pos>
+func ValidateStartFrom(s string) error {
+	switch {
+	case s == "", s == ModeOldest, s == ModeNewest:
+		return nil
+	case strings.HasPrefix(s, "time:"):
+		_, err := time.Parse(time.RFC3339, s[len("time:"):])
+		return err
+	case strings.HasPrefix(s, "binlog:"):
+		_, err := ParsePosition(s[len("binlog:"):])
+		return err
+	}
+	return errors.Errorf("bad StartFrom: %s", s)
+}
+
+// StartOffset 按 MaxWell.StartFrom 计算第一条要消费的 offset
+func StartOffset(client sarama.Client, consumer sarama.Consumer, topic string, partition int32, startFrom string) (int64, error) {
+	switch {
+	case startFrom == "", startFrom == ModeOldest:
+		return sarama.OffsetOldest, nil
+	case startFrom == ModeNewest:
+		return sarama.OffsetNewest, nil
+	case strings.HasPrefix(startFrom, "time:"):
+		return Resolve(client, topic, &Rewind{Partition: partition, Mode: ModeTime, Arg: startFrom[len("time:"):]})
+	case strings.HasPrefix(startFrom, "binlog:"):
+		target, err := ParsePosition(startFrom[len("binlog:"):])
+		if err != nil {
+			return -1, err
+		}
+		return searchPosition(client, consumer, topic, partition, target)
+	}
+
+	return -1, errors.Errorf("bad StartFrom: %s", startFrom)
+}
+
+// searchPosition 二分查找第一条 binlog 位置不小于 target 的消息
+func searchPosition(client sarama.Client, consumer sarama.Consumer, topic string, partition int32, target Position) (int64, error) {
+	lo, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return -1, err
+	}
+
+	hi, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return -1, err
+	}
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		pos, ok, err := positionAt(consumer, topic, partition, mid, hi)
+		if err != nil {
+			return -1, err
+		}
+
+		if ok && pos.Less(target) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, nil
+}
+
+// positionAt 返回 offset 处或之后第一条带 position 的消息的位置, bootstrap 等消息没有 position
+func positionAt(consumer sarama.Consumer, topic string, partition int32, offset, newest int64) (Position, bool, error) {
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return Position{}, false, err
+	}
+	defer func() { _ = pc.Close() }()
+
+	timeout := time.After(time.Second * 30)
+	for {
+		select {
+		case msg := <-pc.Messages():
+			var r struct {
+				Position string `json:"position"`
+			}
+
+			if err := json.Unmarshal(msg.Value, &r); err == nil && len(r.Position) > 0 {
+				pos, err := ParsePosition(r.Position)
+				return pos, err == nil, err
+			}
+
+			if msg.Offset+1 >= newest {
+				return Position{}, false, nil
+			}
+		case err := <-pc.Errors():
+			return Position{}, false, err
+		case <-timeout:
+			return Position{}, false, errors.Errorf("read offset %d timeout", offset)
+		}
+	}
+}
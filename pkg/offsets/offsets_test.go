@@ -0,0 +1,25 @@
+package offsets
+
+import "testing"
+
+func TestPosition(t *testing.T) {
+	a, err := ParsePosition("master.000009:9275773")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ParsePosition("master.000010:4")
+	if !a.Less(b) || b.Less(a) || a.String() != "master.000009:9275773" {
+		t.Errorf("bad compare: %s %s", a, b)
+	}
+
+	for _, s := range []string{"", "oldest", "newest", "time:2019-09-18T06:48:20+08:00", "binlog:master.000009:9275773"} {
+		if err := ValidateStartFrom(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+
+	if err := ValidateStartFrom("binlog:master.000009"); err == nil {
+		t.Errorf("want error")
+	}
+}
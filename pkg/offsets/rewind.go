@@ -0,0 +1,97 @@
+package offsets
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// Store 是 sink 中和 rewind 相关的方法
+type Store interface {
+	Dump(msg *sarama.ConsumerMessage) error
+	Discard()
+	Pending() int // 写入后仍在缓存中的条数, 即还没有结束的事务
+	LastOffset() (int64, error)
+	ResetOffset(r *Rewind) error
+}
+
+// ErrTxOpen 表示写入缓存后仍有没有结束的事务, 不丢弃时不能 rewind
+var ErrTxOpen = errors.New("a transaction is still open, retry later or rewind with discard")
+
+// Rewinder 在消费协程内执行 rewind, PC 是当前的分区消费者.
+// 先检查新的 offset, 重新开始消费成功后再保存; 任何一步失败都回到原来的位置继续消费,
+// 无法恢复时 PC 为 nil, 调用方应该退出
+type Rewinder struct {
+	Client   OffsetGetter
+	Consumer sarama.Consumer
+	Store    Store
+	Topic    string
+	Start    int64 // 启动时的 offset, 没有保存过 offset 时从这里恢复
+	PC       sarama.PartitionConsumer
+}
+
+func (w *Rewinder) Rewind(r *Rewind) error {
+	if r.Partition != 0 {
+		return errors.Errorf("partition %d is not consumed", r.Partition)
+	}
+
+	to, err := Resolve(w.Client, w.Topic, r)
+	if err != nil {
+		return err
+	}
+
+	// 未结束的事务不能跨过 rewind: 只在 Discard 时丢弃, 否则先写入, 仍有未结束的事务时拒绝
+	if r.Discard {
+		w.Store.Discard()
+	} else {
+		if err := w.Store.Dump(nil); err != nil {
+			return err
+		}
+		if w.Store.Pending() > 0 {
+			return ErrTxOpen
+		}
+	}
+
+	last, err := w.Store.LastOffset()
+	if err != nil {
+		return err
+	}
+
+	resume := w.Start
+	r.From = -1
+	if last != Unset {
+		resume, r.From = last+1, last+1
+	}
+	r.To = to
+
+	w.closePC()
+	pc, err := w.Consumer.ConsumePartition(w.Topic, r.Partition, to)
+	if err != nil {
+		return w.resume(resume, err)
+	}
+
+	if err := w.Store.ResetOffset(r); err != nil {
+		_ = pc.Close()
+		return w.resume(resume, err)
+	}
+
+	w.PC = pc
+	return nil
+}
+
+func (w *Rewinder) closePC() {
+	if w.PC != nil {
+		_ = w.PC.Close()
+		w.PC = nil
+	}
+}
+
+// resume 回到 offset 继续消费, 返回 rewind 失败的原因
+func (w *Rewinder) resume(offset int64, cause error) error {
+	pc, err := w.Consumer.ConsumePartition(w.Topic, 0, offset)
+	if err != nil {
+		return errors.Wrapf(cause, "resume at %d: %v", offset, err)
+	}
+
+	w.PC = pc
+	return cause
+}
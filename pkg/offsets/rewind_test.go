@@ -0,0 +1,156 @@
+package offsets
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+type fakeClient struct{ oldest, newest int64 }
+
+func (c *fakeClient) GetOffset(topic string, partition int32, t int64) (int64, error) {
+	if t == sarama.OffsetOldest {
+		return c.oldest, nil
+	}
+	return c.newest, nil
+}
+
+type fakePC struct {
+	sarama.PartitionConsumer
+	offset int64
+	closed bool
+}
+
+func (pc *fakePC) Close() error {
+	pc.closed = true
+	return nil
+}
+
+// fakeConsumer 在 fail 中的 offset 上 ConsumePartition 失败
+type fakeConsumer struct {
+	sarama.Consumer
+	fail map[int64]bool
+	pcs  []*fakePC
+}
+
+func (c *fakeConsumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	if c.fail[offset] {
+		return nil, sarama.ErrOffsetOutOfRange
+	}
+	pc := &fakePC{offset: offset}
+	c.pcs = append(c.pcs, pc)
+	return pc, nil
+}
+
+// fakeStore 中 cached 条缓存, 写入时只写入前 cached-open 条, 剩下的是未结束的事务
+type fakeStore struct {
+	last     int64
+	cached   int
+	open     int
+	resetErr error
+	resets   []*Rewind
+}
+
+func (s *fakeStore) Dump(msg *sarama.ConsumerMessage) error {
+	s.last += int64(s.cached - s.open)
+	s.cached = s.open
+	return nil
+}
+
+func (s *fakeStore) Discard()                   { s.cached, s.open = 0, 0 }
+func (s *fakeStore) Pending() int               { return s.cached }
+func (s *fakeStore) LastOffset() (int64, error) { return s.last, nil }
+func (s *fakeStore) ResetOffset(r *Rewind) error {
+	if s.resetErr != nil {
+		return s.resetErr
+	}
+	s.resets = append(s.resets, r)
+	return nil
+}
+
+func TestResolve(t *testing.T) {
+	c := &fakeClient{oldest: 10, newest: 20}
+	for arg, ok := range map[string]bool{"9": false, "10": true, "20": true, "21": false, "x": false} {
+		to, err := Resolve(c, "t", &Rewind{Mode: ModeOffset, Arg: arg})
+		if ok != (err == nil) {
+			t.Errorf("%s: got %d, %v", arg, to, err)
+		}
+	}
+}
+
+func TestRewinder(t *testing.T) {
+	newRewinder := func(store *fakeStore, fail ...int64) (*Rewinder, *fakePC) {
+		c := &fakeConsumer{fail: make(map[int64]bool)}
+		for _, n := range fail {
+			c.fail[n] = true
+		}
+		pc := &fakePC{offset: 16}
+		return &Rewinder{Client: &fakeClient{oldest: 10, newest: 20}, Consumer: c, Store: store, Topic: "t", Start: 5, PC: pc}, pc
+	}
+
+	// 超出范围时不停止消费
+	store := &fakeStore{last: 15}
+	w, pc := newRewinder(store)
+	if err := w.Rewind(&Rewind{Mode: ModeOffset, Arg: "30"}); errors.Cause(err) != ErrOutOfRange {
+		t.Errorf("expect out of range, got %v", err)
+	}
+	if pc.closed || w.PC != pc || len(store.resets) != 0 {
+		t.Error("expect the consumer untouched")
+	}
+
+	// 成功时先开始消费再保存
+	w, pc = newRewinder(store)
+	if err := w.Rewind(&Rewind{Mode: ModeOffset, Arg: "12"}); err != nil {
+		t.Fatal(err)
+	}
+	if !pc.closed || w.PC.(*fakePC).offset != 12 || len(store.resets) != 1 || store.resets[0].From != 16 || store.resets[0].To != 12 {
+		t.Errorf("bad rewind: %+v", store.resets)
+	}
+
+	// 无法从新的 offset 消费时回到原来的位置, 不保存
+	store = &fakeStore{last: 15}
+	w, _ = newRewinder(store, 12)
+	if err := w.Rewind(&Rewind{Mode: ModeOffset, Arg: "12"}); err == nil {
+		t.Error("expect error")
+	}
+	if w.PC == nil || w.PC.(*fakePC).offset != 16 || len(store.resets) != 0 {
+		t.Error("expect to resume at 16 without saving")
+	}
+
+	// 保存失败时关闭新的消费者并恢复; 没有保存过 offset 时回到 Start
+	store = &fakeStore{last: Unset, resetErr: errors.New("disk full")}
+	w, _ = newRewinder(store)
+	if err := w.Rewind(&Rewind{Mode: ModeOffset, Arg: "12"}); err == nil {
+		t.Error("expect error")
+	}
+	c := w.Consumer.(*fakeConsumer)
+	if len(c.pcs) != 2 || !c.pcs[0].closed || w.PC != c.pcs[1] || c.pcs[1].offset != 5 {
+		t.Error("expect to close the new consumer and resume at Start")
+	}
+
+	// 不丢弃时先写入, 有未结束的事务时拒绝, 不动消费者和缓存
+	store = &fakeStore{last: 15, cached: 3, open: 2}
+	w, pc = newRewinder(store)
+	if err := w.Rewind(&Rewind{Mode: ModeOffset, Arg: "12"}); err != ErrTxOpen {
+		t.Errorf("expect ErrTxOpen, got %v", err)
+	}
+	if pc.closed || store.last != 16 || store.Pending() != 2 || len(store.resets) != 0 {
+		t.Errorf("expect the open transaction kept: %+v", store)
+	}
+
+	// 丢弃时不写入
+	w, _ = newRewinder(store)
+	if err := w.Rewind(&Rewind{Mode: ModeOffset, Arg: "12", Discard: true}); err != nil {
+		t.Fatal(err)
+	}
+	if store.Pending() != 0 || store.last != 16 || store.resets[0].From != 17 {
+		t.Errorf("bad discard: %+v", store)
+	}
+
+	// 恢复也失败时 PC 为 nil
+	w, _ = newRewinder(&fakeStore{last: 15}, 12, 16)
+	if err := w.Rewind(&Rewind{Mode: ModeOffset, Arg: "12"}); err == nil || w.PC != nil {
+		t.Errorf("expect nil PC, got %v", err)
+	}
+}
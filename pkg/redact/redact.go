@@ -0,0 +1,228 @@
+package redact
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ActionDrop    = "drop"    // 删除列
+	ActionHash    = "hash"    // hmac-sha256, 相同的值得到相同的结果, 可以用于关联
+	ActionMask    = "mask"    // 部分打码
+	ActionEncrypt = "encrypt" // aes-gcm 加密, 有权限的调用方查询时解密
+)
+
+// 处理过的值带前缀, 重复处理时跳过
+const (
+	hashPrefix    = "hmac:"
+	encryptPrefix = "enc:"
+)
+
+// Rule 在 [[TableConfigs.xxx.Redact]] 中配置
+type Rule struct {
+	Column     string `mapstructure:"Column"`
+	Action     string `mapstructure:"Action"`
+	KeepPrefix int    `mapstructure:"KeepPrefix"` // mask, 保留开头的字符数
+	KeepSuffix int    `mapstructure:"KeepSuffix"` // mask, 保留末尾的字符数
+	KeepDomain bool   `mapstructure:"KeepDomain"` // mask, 保留邮箱 @ 之后的部分
+}
+
+// Keys 在 [Misc.Redact] 中配置
+type Keys struct {
+	HashKey    string `mapstructure:"HashKey"`
+	EncryptKey string `mapstructure:"EncryptKey"` // hex 编码的 16/24/32 字节 aes 密钥
+}
+
+type Redactor struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+func New(k *Keys) (*Redactor, error) {
+	r := &Redactor{}
+	if k == nil {
+		return r, nil
+	}
+
+	r.hashKey = []byte(k.HashKey)
+	if len(k.EncryptKey) > 0 {
+		key, err := hex.DecodeString(k.EncryptKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad EncryptKey")
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad EncryptKey")
+		}
+
+		if r.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Validate 检查规则, hash 和 encrypt 需要配置对应的密钥
+func (r *Redactor) Validate(rules []*Rule) error {
+	for _, rule := range rules {
+		if len(rule.Column) == 0 {
+			return errors.New("redact: empty Column")
+		}
+
+		switch rule.Action {
+		case ActionDrop, ActionMask:
+		case ActionHash:
+			if len(r.hashKey) == 0 {
+				return errors.Errorf("redact %s: HashKey is required", rule.Column)
+			}
+		case ActionEncrypt:
+			if r.aead == nil {
+				return errors.Errorf("redact %s: EncryptKey is required", rule.Column)
+			}
+		default:
+			return errors.Errorf("redact %s: bad Action %q", rule.Column, rule.Action)
+		}
+	}
+	return nil
+}
+
+// Apply 按规则原地处理 data, 已经处理过的值不会重复处理, null 保持不变
+func (r *Redactor) Apply(rules []*Rule, data map[string]json.RawMessage) {
+	for _, rule := range rules {
+		v, ok := data[rule.Column]
+		if !ok {
+			continue
+		}
+
+		if rule.Action == ActionDrop {
+			delete(data, rule.Column)
+			continue
+		}
+
+		if len(v) == 0 || string(v) == "null" {
+			continue
+		}
+
+		switch rule.Action {
+		case ActionHash:
+			if isString(v, hashPrefix) {
+				continue
+			}
+			h := hmac.New(sha256.New, r.hashKey)
+			_, _ = h.Write(v)
+			data[rule.Column] = quote(hashPrefix + hex.EncodeToString(h.Sum(nil)))
+		case ActionMask:
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				s = string(v) // 数字等
+			}
+			data[rule.Column] = quote(rule.mask(s))
+		case ActionEncrypt:
+			if isString(v, encryptPrefix) {
+				continue
+			}
+			nonce := make([]byte, r.aead.NonceSize())
+			_, _ = io.ReadFull(rand.Reader, nonce)
+			ct := r.aead.Seal(nonce, nonce, v, nil)
+			data[rule.Column] = quote(encryptPrefix + base64.StdEncoding.EncodeToString(ct))
+		}
+	}
+}
+
+// Reveal 解密 encrypt 的列, 还原加密前的 json 值
+func (r *Redactor) Reveal(rules []*Rule, data map[string]json.RawMessage) {
+	if r.aead == nil {
+		return
+	}
+
+	for _, rule := range rules {
+		v, ok := data[rule.Column]
+		if rule.Action != ActionEncrypt || !ok || !isString(v, encryptPrefix) {
+			continue
+		}
+
+		var s string
+		_ = json.Unmarshal(v, &s)
+		ct, err := base64.StdEncoding.DecodeString(s[len(encryptPrefix):])
+		if err != nil || len(ct) < r.aead.NonceSize() {
+			continue
+		}
+
+		ns := r.aead.NonceSize()
+		if pt, err := r.aead.Open(nil, ct[:ns], ct[ns:], nil); err == nil {
+			data[rule.Column] = pt
+		}
+	}
+}
+
+// Message 处理 maxwell 消息中的 data 和 old, 没有规则时原样返回
+func (r *Redactor) Message(rules []*Rule, value []byte) []byte {
+	if len(rules) == 0 {
+		return value
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(value, &m); err != nil {
+		return value
+	}
+
+	for _, field := range []string{"data", "old"} {
+		if len(m[field]) == 0 || m[field][0] != '{' {
+			continue
+		}
+
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal(m[field], &data); err != nil {
+			continue
+		}
+
+		r.Apply(rules, data)
+		m[field], _ = json.Marshal(data)
+	}
+
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return value
+	}
+	return bs
+}
+
+// mask 保留开头/末尾的字符以及邮箱域名, 其他替换成 *
+func (rule *Rule) mask(s string) string {
+	domain := ""
+	if i := strings.LastIndexByte(s, '@'); rule.KeepDomain && i >= 0 {
+		s, domain = s[:i], s[i:]
+	}
+
+	n := utf8.RuneCountInString(s)
+	if rule.KeepPrefix+rule.KeepSuffix >= n {
+		return strings.Repeat("*", n) + domain
+	}
+
+	runes := []rune(s)
+	for i := rule.KeepPrefix; i < n-rule.KeepSuffix; i++ {
+		runes[i] = '*'
+	}
+	return string(runes) + domain
+}
+
+func isString(v json.RawMessage, prefix string) bool {
+	return len(v) > len(prefix) && v[0] == '"' && strings.HasPrefix(string(v[1:]), prefix)
+}
+
+func quote(s string) json.RawMessage {
+	bs, _ := json.Marshal(s)
+	return bs
+}
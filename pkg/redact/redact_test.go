@@ -0,0 +1,63 @@
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := New(&Keys{HashKey: "k", EncryptKey: strings.Repeat("ab", 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []*Rule{
+		{Column: "password", Action: ActionDrop},
+		{Column: "salt", Action: ActionHash},
+		{Column: "mail", Action: ActionMask, KeepPrefix: 2, KeepDomain: true},
+		{Column: "id_card", Action: ActionEncrypt},
+		{Column: "mobile", Action: ActionMask, KeepSuffix: 4},
+	}
+	if err := r.Validate(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	value := []byte(`{"type":"update","data":{"id":1,"password":"p","salt":"s","mail":"Lola.blair62@gmail.com","id_card":"110101","mobile":null},"old":{"salt":"s0"}}`)
+	var m struct {
+		Data map[string]json.RawMessage `json:"data"`
+		Old  map[string]json.RawMessage `json:"old"`
+	}
+	if err := json.Unmarshal(r.Message(rules, value), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Data["password"]; ok {
+		t.Error("password is not dropped")
+	}
+	if string(m.Data["mail"]) != `"Lo**********@gmail.com"` || string(m.Data["mobile"]) != "null" {
+		t.Error(string(m.Data["mail"]), string(m.Data["mobile"]))
+	}
+	if !isString(m.Data["salt"], hashPrefix) || !isString(m.Old["salt"], hashPrefix) || string(m.Data["salt"]) == string(m.Old["salt"]) {
+		t.Error(string(m.Data["salt"]), string(m.Old["salt"]))
+	}
+	if !isString(m.Data["id_card"], encryptPrefix) {
+		t.Error(string(m.Data["id_card"]))
+	}
+
+	// 重复处理结果不变
+	salt, card := string(m.Data["salt"]), string(m.Data["id_card"])
+	r.Apply(rules, m.Data)
+	if string(m.Data["salt"]) != salt || string(m.Data["id_card"]) != card {
+		t.Error("not idempotent")
+	}
+
+	r.Reveal(rules, m.Data)
+	if string(m.Data["id_card"]) != `"110101"` {
+		t.Error(string(m.Data["id_card"]))
+	}
+
+	if err := (&Redactor{}).Validate([]*Rule{{Column: "salt", Action: ActionHash}}); err == nil {
+		t.Error("expect HashKey is required")
+	}
+}
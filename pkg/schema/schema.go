@@ -0,0 +1,193 @@
+package schema
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Column 是 maxwell def 中的一列
+type Column struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Charset      string   `json:"charset,omitempty"`
+	Signed       *bool    `json:"signed,omitempty"`
+	ColumnLength int      `json:"column-length,omitempty"`
+	Precision    int      `json:"precision,omitempty"` // decimal
+	Scale        int      `json:"scale,omitempty"`     // decimal
+	EnumValues   []string `json:"enum-values,omitempty"`
+}
+
+// Def 是 maxwell table-create 和 table-alter 消息中的 def
+type Def struct {
+	Database   string    `json:"database"`
+	Table      string    `json:"table"`
+	Charset    string    `json:"charset"`
+	PrimaryKey []string  `json:"primary-key"`
+	Columns    []*Column `json:"columns"`
+}
+
+// Version 是一张表的一个结构版本, Def 为 nil 表示表被删除或者改名
+type Version struct {
+	Table   string `json:"table"` // db.table
+	Version int    `json:"version"`
+	Type    string `json:"type"` // table-create, table-alter, table-drop
+	Offset  int64  `json:"offset"`
+	Ts      int64  `json:"ts"`
+	SQL     string `json:"sql,omitempty"`
+	Def     *Def   `json:"def"`
+}
+
+// IsDDL 判断 maxwell 消息的 type 是否是 ddl
+func IsDDL(typ string) bool {
+	return strings.HasPrefix(typ, "table-") || strings.HasPrefix(typ, "database-")
+}
+
+// Parse 解析 maxwell 的 ddl 消息, 返回受影响的表的新版本. database-* 不影响表结构, 返回 nil.
+// 改名的 table-alter 返回两个版本: 旧表的 Def 为 nil, 新表是改名后的结构
+func Parse(value []byte, offset int64) ([]*Version, error) {
+	var r struct {
+		Type     string `json:"type"`
+		Database string `json:"database"`
+		Table    string `json:"table"`
+		Ts       int64  `json:"ts"`
+		SQL      string `json:"sql"`
+		Def      *Def   `json:"def"`
+		Old      *Def   `json:"old"`
+	}
+
+	if err := json.Unmarshal(value, &r); err != nil {
+		return nil, err
+	}
+
+	v := &Version{Table: Name(r.Database, r.Table), Type: r.Type, Offset: offset, Ts: r.Ts, SQL: r.SQL}
+	switch r.Type {
+	case "table-create":
+		v.Def = r.Def
+	case "table-alter":
+		v.Def = r.Def
+		if r.Def != nil {
+			v.Table = Name(r.Def.Database, r.Def.Table)
+		}
+		if r.Old != nil && Name(r.Old.Database, r.Old.Table) != v.Table {
+			old := *v
+			old.Table, old.Def = Name(r.Old.Database, r.Old.Table), nil
+			return []*Version{&old, v}, nil
+		}
+	case "table-drop":
+	default:
+		return nil, nil
+	}
+	return []*Version{v}, nil
+}
+
+// Name 返回 registry 中的表名 db.table
+func Name(database, table string) string {
+	return database + "." + table
+}
+
+// ColumnType 返回列的类型, 没有定义时返回空字符串
+func (def *Def) ColumnType(name string) string {
+	if def == nil {
+		return ""
+	}
+
+	for _, c := range def.Columns {
+		if c.Name == name {
+			return c.Type
+		}
+	}
+	return ""
+}
+
+// ColumnNames 按建表顺序返回 data 中的列, 没有 def 或者 def 不完整时按列名排序
+func (def *Def) ColumnNames(data map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(data))
+	if def != nil {
+		for _, c := range def.Columns {
+			if _, ok := data[c.Name]; ok {
+				names = append(names, c.Name)
+			}
+		}
+		if len(names) == len(data) {
+			return names
+		}
+		names = names[:0]
+	}
+
+	for k := range data {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registry 在内存中保存每张表的结构历史, 由 sink 负责持久化
+type Registry struct {
+	mu     sync.RWMutex
+	tables map[string][]*Version
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tables: make(map[string][]*Version)}
+}
+
+// Add 追加一个版本并设置 Version. rewind 后重放的 ddl (offset 不大于最后一个版本) 返回 false
+func (r *Registry) Add(v *Version) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := r.tables[v.Table]
+	if n := len(history); n > 0 && v.Offset <= history[n-1].Offset {
+		return false
+	}
+
+	v.Version = len(history) + 1
+	r.tables[v.Table] = append(history, v)
+	return true
+}
+
+// Load 加载持久化的版本, 不重新编号
+func (r *Registry) Load(v *Version) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := append(r.tables[v.Table], v)
+	sort.Slice(history, func(i, j int) bool { return history[i].Offset < history[j].Offset })
+	r.tables[v.Table] = history
+}
+
+// History 返回表的全部版本, 由旧到新
+func (r *Registry) History(table string) []*Version {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]*Version(nil), r.tables[table]...)
+}
+
+// At 返回 ts 时刻生效的版本, 没有时返回 nil
+func (r *Registry) At(table string, ts int64) *Version {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := r.tables[table]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Ts <= ts {
+			return history[i]
+		}
+	}
+	return nil
+}
+
+// Def 返回表当前的结构, 没有或者表已经删除时返回 nil
+func (r *Registry) Def(table string) *Def {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := r.tables[table]
+	if len(history) == 0 {
+		return nil
+	}
+	return history[len(history)-1].Def
+}
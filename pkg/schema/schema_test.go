@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	create := []byte(`{"type":"table-create","database":"hsn","table":"user_info","ts":100,"sql":"CREATE TABLE user_info (id int)",` +
+		`"def":{"database":"hsn","table":"user_info","charset":"utf8mb4","primary-key":["id"],"columns":[{"type":"int","name":"id","signed":true}]}}`)
+	rename := []byte(`{"type":"table-alter","database":"hsn","table":"user_info","ts":200,"sql":"RENAME TABLE user_info TO users",` +
+		`"old":{"database":"hsn","table":"user_info","columns":[{"type":"int","name":"id"}]},` +
+		`"def":{"database":"hsn","table":"users","primary-key":["id"],"columns":[{"type":"int","name":"id"},{"type":"varchar","name":"name"}]}}`)
+
+	r := NewRegistry()
+	for i, value := range [][]byte{create, rename} {
+		versions, err := Parse(value, int64(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range versions {
+			if !r.Add(v) {
+				t.Fatalf("expect added: %s", v.Table)
+			}
+		}
+	}
+
+	if versions, _ := Parse(create, 1); r.Add(versions[0]) {
+		t.Fatal("expect replayed ddl to be ignored")
+	}
+
+	if h := r.History("hsn.user_info"); len(h) != 2 || h[1].Version != 2 || h[1].Def != nil {
+		t.Fatalf("bad history: %+v", h)
+	}
+
+	if v := r.At("hsn.user_info", 150); v == nil || v.Version != 1 || v.Def.ColumnType("id") != "int" {
+		t.Fatalf("bad version at 150: %+v", v)
+	}
+
+	if def := r.Def("hsn.users"); def == nil || len(def.Columns) != 2 {
+		t.Fatalf("bad def: %+v", def)
+	}
+
+	if versions, err := Parse([]byte(`{"type":"database-create","database":"x"}`), 3); err != nil || versions != nil {
+		t.Fatalf("expect database ddl to be ignored: %v %v", versions, err)
+	}
+}
@@ -0,0 +1,46 @@
+package script
+
+import (
+	"context"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// 虚拟机的固定上限, 超过时脚本出错而不是继续增长.
+// lua 的对象分配在 go 的堆上, 没有按虚拟机统计的方法, 字符串拼接等增长只受 Timeout 限制
+const (
+	callStackSize = 256      // 函数调用的深度
+	registrySize  = 256 * 20 // lua 栈的槽数, 不自动增长
+)
+
+func newState() *lua.LState {
+	return lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: callStackSize, RegistrySize: registrySize})
+}
+
+// guard 在 timeout 内执行 f, 超时时中断脚本
+func (s *Script) guard(L *lua.LState, f func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	L.SetContext(ctx)
+	err := f()
+	L.RemoveContext()
+	return err
+}
+
+// rep 替换 string.rep, 结果超过 MaxString 时报错而不是直接分配
+func (s *Script) rep(L *lua.LState) int {
+	str, n := L.CheckString(1), L.CheckInt(2)
+	if n <= 0 {
+		L.Push(lua.LString(""))
+		return 1
+	}
+
+	if len(str) > 0 && uint64(n) > s.maxString/uint64(len(str)) {
+		L.RaiseError("string.rep: result exceeds MaxString of %dMB", s.maxString>>20)
+		return 0
+	}
+	L.Push(lua.LString(strings.Repeat(str, n)))
+	return 1
+}
@@ -0,0 +1,55 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Test 用样例事件测试脚本, r 是 ndjson 或者 json 数组, 每个事件的结果输出到 w
+func (s *Script) Test(r io.Reader, w io.Writer) error {
+	d := json.NewDecoder(r)
+	i, out, dropped := 0, 0, 0
+	for {
+		var v json.RawMessage
+		if err := d.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		events := []json.RawMessage{v}
+		if len(v) > 0 && v[0] == '[' {
+			if err := json.Unmarshal(v, &events); err != nil {
+				return err
+			}
+		}
+
+		for _, e := range events {
+			i++
+			values, err := s.Run(e)
+			if err == nil {
+				err = Check(values)
+			}
+			if err != nil {
+				_, _ = fmt.Fprintf(w, "# %d: error: %v\n", i, err)
+				continue
+			}
+
+			if len(values) == 0 {
+				dropped++
+				_, _ = fmt.Fprintf(w, "# %d: dropped\n", i)
+				continue
+			}
+
+			out += len(values)
+			_, _ = fmt.Fprintf(w, "# %d: %d event(s)\n", i, len(values))
+			for _, v := range values {
+				_, _ = fmt.Fprintf(w, "%s\n", v)
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "# in %d, out %d, dropped %d\n", i, out, dropped)
+	return err
+}
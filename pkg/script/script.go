@@ -0,0 +1,343 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/helloshiki/maxwell-output/pkg/schema"
+	"github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// DefaultTimeout 是每个事件默认的执行时间
+const DefaultTimeout = 100 * time.Millisecond
+
+// DefaultMaxString 是 string.rep 结果默认的上限 (MB)
+const DefaultMaxString = 64
+
+// 脚本出错时的处理, 输出的事件不合法 (见 Check) 也算出错
+const (
+	OnErrorKeep = "keep" // 记录日志并保留原消息
+	OnErrorDrop = "drop" // 记录日志并丢弃消息
+	OnErrorStop = "stop" // 写入失败, 停止消费, 不保存 offset
+)
+
+// Config 在 [TableConfigs.xxx.Script] 中配置.
+// 脚本定义 process(event) 函数, event 是解码后的 maxwell 消息:
+// 不返回 (nil) 时保留修改后的 event, 返回 false 丢弃, 返回一个 table 替换, 返回 table 数组拆分成多个事件
+type Config struct {
+	File      string        `mapstructure:"File"`
+	Timeout   time.Duration `mapstructure:"Timeout"`   // 每个事件的执行时间, 默认 100ms
+	Reload    time.Duration `mapstructure:"Reload"`    // 检查文件修改的间隔, 0 不热加载
+	OnError   string        `mapstructure:"OnError"`   // keep (默认), drop, stop
+	MaxString int           `mapstructure:"MaxString"` // string.rep 结果的上限 (MB), 默认 64
+
+	script *Script
+}
+
+// Validate 检查配置并加载脚本
+func (c *Config) Validate() error {
+	switch c.OnError {
+	case "":
+		c.OnError = OnErrorKeep
+	case OnErrorKeep, OnErrorDrop, OnErrorStop:
+	default:
+		return errors.Errorf("bad OnError: %s", c.OnError)
+	}
+
+	s, err := Load(c.File, c.Timeout)
+	if err != nil {
+		return err
+	}
+	s.reload = c.Reload
+	if c.MaxString > 0 {
+		s.maxString = uint64(c.MaxString) << 20
+	}
+	c.script = s
+	return nil
+}
+
+// Script 返回加载的脚本, Validate 之前为 nil
+func (c *Config) Script() *Script {
+	return c.script
+}
+
+// Script 是编译好的脚本和它的 lua 虚拟机, 可以并发调用
+type Script struct {
+	file      string
+	timeout   time.Duration
+	reload    time.Duration
+	maxString uint64 // 字节
+
+	mu      sync.Mutex
+	proto   *lua.FunctionProto
+	modTime time.Time
+	checked time.Time
+	L       *lua.LState
+	null    *lua.LUserData
+}
+
+// Load 编译脚本并检查 process 函数
+func Load(file string, timeout time.Duration) (*Script, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	s := &Script{file: file, timeout: timeout, maxString: DefaultMaxString << 20}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Script) load() error {
+	fi, err := os.Stat(s.file)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	chunk, err := parse.Parse(f, s.file)
+	if err != nil {
+		return errors.Wrap(err, "parse script")
+	}
+
+	proto, err := lua.Compile(chunk, s.file)
+	if err != nil {
+		return errors.Wrap(err, "compile script")
+	}
+
+	old := s.proto
+	s.proto = proto
+	if err := s.init(); err != nil {
+		s.proto = old
+		return err
+	}
+	s.modTime, s.checked = fi.ModTime(), time.Now()
+	return nil
+}
+
+// init 创建新的虚拟机并执行脚本, 只打开 base, table, string 和 math 库
+func (s *Script) init() error {
+	L := newState()
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetField(L.GetGlobal(lua.StringLibName), "rep", L.NewFunction(s.rep))
+
+	null := L.NewUserData()
+	L.SetGlobal("null", null)
+
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := s.guard(L, func() error { return L.PCall(0, 0, nil) }); err != nil {
+		L.Close()
+		return errors.Wrap(err, "run script")
+	}
+
+	if _, ok := L.GetGlobal("process").(*lua.LFunction); !ok {
+		L.Close()
+		return errors.New("script must define function process(event)")
+	}
+
+	if s.L != nil {
+		s.L.Close()
+	}
+	s.L, s.null = L, null
+	return nil
+}
+
+// Reload 每隔 Reload 检查一次文件, 修改过时重新加载. 返回是否重新加载, 加载失败时继续使用旧的脚本
+func (s *Script) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reload <= 0 || time.Since(s.checked) < s.reload {
+		return false, nil
+	}
+	s.checked = time.Now()
+
+	fi, err := os.Stat(s.file)
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(s.modTime) {
+		return false, nil
+	}
+
+	if err := s.load(); err != nil {
+		s.modTime = fi.ModTime() // 文件再次修改前不重试
+		return false, err
+	}
+	return true, nil
+}
+
+// Run 用脚本处理一条 maxwell 消息, 返回处理后的消息, 为空表示丢弃
+func (s *Script) Run(value []byte) ([][]byte, error) {
+	var event interface{}
+	d := json.NewDecoder(bytes.NewReader(value))
+	d.UseNumber()
+	if err := d.Decode(&event); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.L == nil { // 上次超时或者出错后重建
+		if err := s.init(); err != nil {
+			return nil, err
+		}
+	}
+
+	L := s.L
+	e := s.toLua(event)
+	err := s.guard(L, func() error {
+		return L.CallByParam(lua.P{Fn: L.GetGlobal("process"), NRet: 1, Protect: true}, e)
+	})
+	if err != nil {
+		L.Close()
+		s.L = nil
+		return nil, err
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	var events []lua.LValue
+	switch r := ret.(type) {
+	case *lua.LNilType:
+		events = []lua.LValue{e}
+	case lua.LBool:
+		if r {
+			events = []lua.LValue{e}
+		}
+	case *lua.LTable:
+		if r.MaxN() == 0 {
+			events = []lua.LValue{r}
+			break
+		}
+		for i := 1; i <= r.MaxN(); i++ {
+			events = append(events, r.RawGetInt(i))
+		}
+	default:
+		return nil, errors.Errorf("process returns %s", ret.Type())
+	}
+
+	values := make([][]byte, 0, len(events))
+	for _, e := range events {
+		if _, ok := e.(*lua.LTable); !ok {
+			return nil, errors.Errorf("process returns %s in the event list", e.Type())
+		}
+
+		v, err := s.fromLua(e, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, bs)
+	}
+	return values, nil
+}
+
+// Check 检查脚本输出的事件. 每个事件必须有库名, 表名, data 对象和 insert/update/delete/bootstrap-insert 类型,
+// 否则写入时无法处理. 拆分出的事件共用原消息的 offset, 而每张表的 log 中一个 offset 只有一条, 所以不能拆分到同一张表
+func Check(values [][]byte) error {
+	tables := make(map[string]bool, len(values))
+	for i, v := range values {
+		var e struct {
+			Type     string          `json:"type"`
+			Database string          `json:"database"`
+			Table    string          `json:"table"`
+			Data     json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(v, &e); err != nil {
+			return errors.Wrapf(err, "event %d", i+1)
+		}
+
+		switch e.Type {
+		case "insert", "update", "delete", "bootstrap-insert":
+		default:
+			return errors.Errorf("event %d: bad type %q", i+1, e.Type)
+		}
+		if len(e.Database) == 0 || len(e.Table) == 0 {
+			return errors.Errorf("event %d: database and table are required", i+1)
+		}
+		if len(e.Data) == 0 || e.Data[0] != '{' {
+			return errors.Errorf("event %d: data must be an object", i+1)
+		}
+
+		t := schema.Name(e.Database, e.Table)
+		if tables[t] {
+			return errors.Errorf("process splits the event into %s more than once", t)
+		}
+		tables[t] = true
+	}
+	return nil
+}
+
+// Key 按处理后的消息重新生成 maxwell 的 key, 库名, 表名和主键都没有变化时返回原来的 key
+func Key(key, value []byte) []byte {
+	var k map[string]json.RawMessage
+	if err := json.Unmarshal(key, &k); err != nil || k == nil {
+		return key
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(value, &m); err != nil {
+		return key
+	}
+
+	var data map[string]json.RawMessage
+	_ = json.Unmarshal(m["data"], &data)
+
+	changed := false
+	set := func(name string, v json.RawMessage) {
+		if len(v) > 0 && !bytes.Equal(k[name], v) {
+			k[name], changed = v, true
+		}
+	}
+
+	set("database", m["database"])
+	set("table", m["table"])
+	for name := range k {
+		if len(name) > 3 && name[:3] == "pk." {
+			set(name, data[name[3:]])
+		}
+	}
+
+	if !changed {
+		return key
+	}
+
+	bs, err := json.Marshal(k)
+	if err != nil {
+		return key
+	}
+	return bs
+}
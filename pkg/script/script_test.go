@@ -0,0 +1,169 @@
+package script
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, file, src string) {
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "hook.lua")
+	writeScript(t, file, `
+function process(e)
+  if e.type == "update" and e.old ~= nil then
+    local only = true
+    for k in pairs(e.old) do
+      if k ~= "update_date" then only = false end
+    end
+    if only then return false end
+  end
+  if e.data.coin_name ~= nil and e.data.coin_name ~= null then
+    e.table = e.table .. "_" .. string.lower(e.data.coin_name)
+  end
+  if e.type == "split" then
+    local a, b = {type = "insert", table = "a", data = e.data}, {type = "insert", table = "b", data = {}}
+    return {a, b}
+  end
+  if e.type == "loop" then
+    while true do end
+  end
+end
+`)
+
+	s, err := Load(file, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		in   string
+		want []string
+	}{
+		{`{"type":"update","table":"t","data":{"id":1},"old":{"update_date":"x"}}`, nil},
+		{`{"type":"insert","table":"coin","data":{"id":12345678901234567890,"coin_name":"USDT","b":0E-8,"c":1.5,"m":null}}`,
+			[]string{`{"data":{"b":0,"c":1.5,"coin_name":"USDT","id":"12345678901234567890","m":null},"table":"coin_usdt","type":"insert"}`}},
+		{`{"type":"split","table":"t","data":{"id":1}}`,
+			[]string{`{"data":{"id":1},"table":"a","type":"insert"}`, `{"data":{},"table":"b","type":"insert"}`}},
+	} {
+		values, err := s.Run([]byte(c.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != len(c.want) {
+			t.Fatalf("%s: got %d events", c.in, len(values))
+		}
+		for i, v := range values {
+			if string(v) != c.want[i] {
+				t.Errorf("got %s, want %s", v, c.want[i])
+			}
+		}
+	}
+
+	if _, err := s.Run([]byte(`{"type":"loop","data":{}}`)); err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("expect timeout, got %v", err)
+	}
+	if values, err := s.Run([]byte(`{"type":"insert","table":"t","data":{"id":1}}`)); err != nil || len(values) != 1 {
+		t.Errorf("expect script to recover after timeout: %v", err)
+	}
+
+	s.reload = time.Nanosecond
+	writeScript(t, file, `function process(e) return false end`)
+	_ = os.Chtimes(file, time.Now().Add(time.Second), time.Now().Add(time.Second))
+	if ok, err := s.Reload(); !ok || err != nil {
+		t.Fatalf("expect reload: %v", err)
+	}
+	if values, _ := s.Run([]byte(`{"type":"insert","data":{}}`)); len(values) != 0 {
+		t.Error("expect the reloaded script to drop the event")
+	}
+}
+
+func TestKey(t *testing.T) {
+	key := []byte(`{"database":"hsn","table":"coin","pk.id":1}`)
+	if got := Key(key, []byte(`{"database":"hsn","table":"coin","data":{"id":1}}`)); string(got) != string(key) {
+		t.Errorf("expect the same key, got %s", got)
+	}
+
+	got := Key(key, []byte(`{"database":"hsn","table":"coin_usdt","data":{"id":2}}`))
+	if string(got) != `{"database":"hsn","pk.id":2,"table":"coin_usdt"}` {
+		t.Errorf("bad key: %s", got)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "hook.lua")
+	writeScript(t, file, `
+function process(e)
+  if e.type == "rep" then
+    e.data.s = string.rep("x", 1e12)
+  elseif e.type == "deep" then
+    local function f(n) return f(n + 1) + 1 end
+    f(1)
+  elseif e.type == "loop" then
+    while true do end
+  end
+end
+`)
+
+	c := &Config{File: file, Timeout: 100 * time.Millisecond, MaxString: 16}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.OnError != OnErrorKeep {
+		t.Errorf("expect OnError keep by default, got %s", c.OnError)
+	}
+
+	for typ, expect := range map[string]string{"rep": "MaxString", "deep": "stack overflow", "loop": "context deadline exceeded"} {
+		if _, err := c.Script().Run([]byte(`{"type":"` + typ + `","data":{}}`)); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expect %s, got %v", typ, expect, err)
+		}
+	}
+
+	if err := (&Config{File: file, OnError: "retry"}).Validate(); err == nil {
+		t.Error("expect bad OnError")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	a := []byte(`{"database":"hsn","table":"a","type":"insert","data":{"id":1}}`)
+	b := []byte(`{"database":"hsn","table":"b","type":"delete","data":{"id":1}}`)
+	if err := Check([][]byte{a, b}); err != nil {
+		t.Error(err)
+	}
+
+	for _, bad := range []string{
+		`{}`,
+		`{"database":"hsn","table":"a","data":{}}`,
+		`{"database":"hsn","table":"a","type":"upsert","data":{}}`,
+		`{"database":"hsn","table":"","type":"insert","data":{}}`,
+		`{"database":"hsn","table":"a","type":"insert","data":[]}`,
+	} {
+		if err := Check([][]byte{[]byte(bad)}); err == nil {
+			t.Errorf("%s: expect error", bad)
+		}
+	}
+
+	if err := Check([][]byte{a, b, a}); err == nil {
+		t.Error("expect error for the same table")
+	}
+}
@@ -0,0 +1,111 @@
+package script
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maxDepth 限制嵌套层数, 防止脚本返回自引用的 table
+const maxDepth = 64
+
+// toLua 把 json 值转成 lua 值. json 的 null 是全局变量 null, 不能用 float64 精确表示的数字转成字符串
+func (s *Script) toLua(v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		t := s.L.NewTable()
+		for k, e := range v {
+			t.RawSetString(k, s.toLua(e))
+		}
+		return t
+	case []interface{}:
+		t := s.L.CreateTable(len(v), 0)
+		for i, e := range v {
+			t.RawSetInt(i+1, s.toLua(e))
+		}
+		return t
+	case json.Number:
+		if f, ok := exactFloat(string(v)); ok {
+			return lua.LNumber(f)
+		}
+		return lua.LString(v)
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	}
+	return s.null
+}
+
+func exactFloat(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, false
+	}
+
+	shortest, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return f, shortest != nil && shortest.Cmp(r) == 0
+}
+
+// fromLua 把 lua 值转成 json 值, 键为 1..n 的 table 是数组, 空 table 是对象
+func (s *Script) fromLua(v lua.LValue, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("table is nested too deeply")
+	}
+
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case *lua.LUserData:
+		if v == s.null {
+			return nil, nil
+		}
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LString:
+		return string(v), nil
+	case lua.LNumber:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errors.Errorf("bad number: %v", f)
+		}
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return json.Number(strconv.FormatInt(int64(f), 10)), nil
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case *lua.LTable:
+		n, count := v.MaxN(), 0
+		v.ForEach(func(lua.LValue, lua.LValue) { count++ })
+		if n > 0 && n == count {
+			a := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				e, err := s.fromLua(v.RawGetInt(i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				a = append(a, e)
+			}
+			return a, nil
+		}
+
+		m := make(map[string]interface{}, count)
+		var err error
+		v.ForEach(func(k, e lua.LValue) {
+			if err != nil {
+				return
+			}
+			m[k.String()], err = s.fromLua(e, depth+1)
+		})
+		return m, err
+	}
+	return nil, errors.Errorf("unsupported value: %s", v.Type())
+}
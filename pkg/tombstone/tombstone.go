@@ -0,0 +1,56 @@
+package tombstone
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// DeleteMode 在 [TableConfigs.xxx] 中配置, 决定 delete 事件如何处理行
+const (
+	Hard = "hard"          // 直接删除, 默认
+	Soft = "soft"          // 保留最后的状态并标记 _deleted
+	TTL  = "tombstone-ttl" // 同 soft, 超过 TombstoneTTL 后清除
+)
+
+// 软删除时写入 data 的列
+const (
+	Deleted       = "_deleted"
+	DeletedAt     = "_deleted_at" // maxwell 的 ts
+	DeletedOffset = "_deleted_offset"
+)
+
+// Validate 检查 DeleteMode, 空字符串等同 hard
+func Validate(mode string) error {
+	switch mode {
+	case "", Hard, Soft, TTL:
+		return nil
+	}
+	return fmt.Errorf("bad DeleteMode: %s", mode)
+}
+
+// IsSoft 为 true 时 delete 事件不删除行
+func IsSoft(mode string) bool {
+	return mode == Soft || mode == TTL
+}
+
+// Mark 在 delete 事件的 data (删除前的行) 上加上软删除标记
+func Mark(data json.RawMessage, ts, offset int64) json.RawMessage {
+	var row map[string]json.RawMessage
+	_ = json.Unmarshal(data, &row)
+	if row == nil {
+		row = make(map[string]json.RawMessage, 3)
+	}
+
+	row[Deleted] = json.RawMessage("true")
+	row[DeletedAt] = json.RawMessage(strconv.FormatInt(ts, 10))
+	row[DeletedOffset] = json.RawMessage(strconv.FormatInt(offset, 10))
+
+	bs, _ := json.Marshal(row)
+	return bs
+}
+
+// IsDeleted 检查行是否带有软删除标记
+func IsDeleted(row map[string]json.RawMessage) bool {
+	return string(row[Deleted]) == "true"
+}
@@ -0,0 +1,29 @@
+package tombstone
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMark(t *testing.T) {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(Mark(json.RawMessage(`{"id":1,"name":"a"}`), 1568760500, 42), &row); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsDeleted(row) || string(row["name"]) != `"a"` {
+		t.Fatalf("bad row: %v", row)
+	}
+
+	if string(row[DeletedAt]) != "1568760500" || string(row[DeletedOffset]) != "42" {
+		t.Fatalf("bad marker: %s %s", row[DeletedAt], row[DeletedOffset])
+	}
+
+	if IsDeleted(map[string]json.RawMessage{"id": json.RawMessage("1")}) {
+		t.Fatal("expect live row")
+	}
+
+	if err := Validate("soft-ish"); err == nil {
+		t.Fatal("expect bad mode")
+	}
+}
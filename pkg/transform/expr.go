@@ -0,0 +1,191 @@
+package transform
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/token"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// value 是表达式的值, num 和 str 都为空时是 null
+type value struct {
+	num    *big.Rat
+	str    *string
+	scale  int  // 输出数字时的小数位数, 取操作数中最大的
+	quoted bool // 操作数中有字符串形式的数字 (例如 Normalize 输出的 decimal), 结果也输出字符串
+}
+
+// check 只允许列名, 字面量, 四则运算和括号, 列名可以是 a.b (展开的 json 列)
+func check(e ast.Expr) error {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return nil
+	case *ast.SelectorExpr:
+		return check(e.X)
+	case *ast.BasicLit:
+		if e.Kind == token.INT || e.Kind == token.FLOAT || e.Kind == token.STRING {
+			return nil
+		}
+	case *ast.ParenExpr:
+		return check(e.X)
+	case *ast.UnaryExpr:
+		if e.Op == token.SUB || e.Op == token.ADD {
+			return check(e.X)
+		}
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO:
+			if err := check(e.X); err != nil {
+				return err
+			}
+			return check(e.Y)
+		}
+	}
+	return errors.Errorf("unsupported expression: %T", e)
+}
+
+// columnName 把 a.b.c 还原成列名
+func columnName(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return columnName(e.X) + "." + e.Sel.Name
+	}
+	return ""
+}
+
+// eval 计算表达式, 任意操作数为 null 或者除以 0 时结果为 null; + 的操作数有非数字的字符串时连接字符串
+func eval(e ast.Expr, data map[string]json.RawMessage) value {
+	switch e := e.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+		return fromJSON(data[columnName(e)])
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			s, _ := strconv.Unquote(e.Value)
+			return value{str: &s}
+		}
+		return fromNumber(e.Value, false)
+	case *ast.ParenExpr:
+		return eval(e.X, data)
+	case *ast.UnaryExpr:
+		v := eval(e.X, data)
+		if e.Op == token.SUB && v.num != nil {
+			v.num = new(big.Rat).Neg(v.num)
+		}
+		return v
+	case *ast.BinaryExpr:
+		x, y := eval(e.X, data), eval(e.Y, data)
+		if x.isNull() || y.isNull() {
+			return value{}
+		}
+
+		if x.num == nil || y.num == nil {
+			if e.Op != token.ADD {
+				return value{}
+			}
+			s := x.text() + y.text()
+			return value{str: &s}
+		}
+
+		res := value{num: new(big.Rat), scale: x.scale, quoted: x.quoted || y.quoted}
+		if y.scale > res.scale {
+			res.scale = y.scale
+		}
+
+		switch e.Op {
+		case token.ADD:
+			res.num.Add(x.num, y.num)
+		case token.SUB:
+			res.num.Sub(x.num, y.num)
+		case token.MUL:
+			res.num.Mul(x.num, y.num)
+			res.scale = x.scale + y.scale
+		case token.QUO:
+			if y.num.Sign() == 0 {
+				return value{}
+			}
+			res.num.Quo(x.num, y.num)
+			if !res.num.IsInt() && res.scale < 8 {
+				res.scale = 8
+			}
+		}
+		return res
+	}
+	return value{}
+}
+
+func fromJSON(v json.RawMessage) value {
+	if len(v) == 0 || string(v) == "null" {
+		return value{}
+	}
+
+	if v[0] == '"' {
+		var s string
+		_ = json.Unmarshal(v, &s)
+		if n := fromNumber(s, true); n.num != nil {
+			return n
+		}
+		return value{str: &s}
+	}
+
+	if n := fromNumber(string(v), false); n.num != nil {
+		return n
+	}
+
+	s := string(v) // true, false, 对象和数组按文本处理
+	return value{str: &s}
+}
+
+// decimalRe 是十进制数; big.Rat.SetString 还接受 1/3, 0x1F 和 1_000, 这些字符串 (比如 "2019/09") 不能当作数字
+var decimalRe = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// parseRat 只解析十进制数
+func parseRat(s string) (*big.Rat, bool) {
+	if !decimalRe.MatchString(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+func fromNumber(s string, quoted bool) value {
+	r, ok := parseRat(s)
+	if !ok {
+		return value{}
+	}
+
+	scale := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 && !strings.ContainsAny(s, "eE") {
+		scale = len(s) - i - 1
+	}
+	return value{num: r, scale: scale, quoted: quoted}
+}
+
+func (v value) isNull() bool {
+	return v.num == nil && v.str == nil
+}
+
+func (v value) text() string {
+	if v.str != nil {
+		return *v.str
+	}
+	if v.num.IsInt() && v.scale == 0 {
+		return v.num.Num().String()
+	}
+	return v.num.FloatString(v.scale)
+}
+
+func (v value) json() json.RawMessage {
+	if v.isNull() {
+		return json.RawMessage("null")
+	}
+	if v.str != nil || v.quoted {
+		return quote(v.text())
+	}
+	return json.RawMessage(v.text())
+}
@@ -0,0 +1,236 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	OpRename     = "rename"     // From -> Column
+	OpDrop       = "drop"       // 删除 Column 或者 Columns
+	OpCast       = "cast"       // Column 转成 Type: string, int, float, bool
+	OpSet        = "set"        // Column = Value
+	OpCopy       = "copy"       // Column = From
+	OpConcat     = "concat"     // Column = Columns 用 Separator 连接, 跳过 null
+	OpExpression = "expression" // Column = Expr, 支持列名, 数字, 字符串, + - * / 和括号
+)
+
+// Step 在 [[TableConfigs.xxx.Transforms]] 中配置, 按顺序执行
+type Step struct {
+	Op        string      `mapstructure:"Op"`
+	Column    string      `mapstructure:"Column"`
+	From      string      `mapstructure:"From"`
+	Columns   []string    `mapstructure:"Columns"`
+	Type      string      `mapstructure:"Type"`
+	Value     interface{} `mapstructure:"Value"`
+	Separator string      `mapstructure:"Separator"`
+	Expr      string      `mapstructure:"Expr"`
+
+	value json.RawMessage
+	expr  ast.Expr
+}
+
+// Validate 检查步骤, 编译表达式
+func Validate(steps []*Step) error {
+	for i, s := range steps {
+		if err := s.validate(); err != nil {
+			return errors.Wrapf(err, "transform %d", i)
+		}
+	}
+	return nil
+}
+
+func (s *Step) validate() error {
+	switch s.Op {
+	case OpDrop:
+		if len(s.Column) == 0 && len(s.Columns) == 0 {
+			return errors.New("drop requires Column or Columns")
+		}
+		return nil
+	case OpRename, OpCopy:
+		if len(s.From) == 0 {
+			return errors.Errorf("%s requires From", s.Op)
+		}
+	case OpCast:
+		switch s.Type {
+		case "string", "int", "float", "bool":
+		default:
+			return errors.Errorf("bad cast type: %s", s.Type)
+		}
+	case OpSet:
+		bs, err := json.Marshal(s.Value)
+		if err != nil {
+			return err
+		}
+		s.value = bs
+	case OpConcat:
+		if len(s.Columns) == 0 {
+			return errors.New("concat requires Columns")
+		}
+	case OpExpression:
+		expr, err := parser.ParseExpr(s.Expr)
+		if err != nil {
+			return errors.Wrap(err, "bad Expr")
+		}
+		if err := check(expr); err != nil {
+			return err
+		}
+		s.expr = expr
+	default:
+		return errors.Errorf("bad op: %s", s.Op)
+	}
+
+	if len(s.Column) == 0 {
+		return errors.Errorf("%s requires Column", s.Op)
+	}
+	return nil
+}
+
+// Apply 按顺序原地处理 data
+func Apply(steps []*Step, data map[string]json.RawMessage) {
+	for _, s := range steps {
+		s.apply(data)
+	}
+}
+
+func (s *Step) apply(data map[string]json.RawMessage) {
+	switch s.Op {
+	case OpRename:
+		if v, ok := data[s.From]; ok {
+			delete(data, s.From)
+			data[s.Column] = v
+		}
+	case OpDrop:
+		delete(data, s.Column)
+		for _, k := range s.Columns {
+			delete(data, k)
+		}
+	case OpCast:
+		if v, ok := data[s.Column]; ok {
+			data[s.Column] = cast(v, s.Type)
+		}
+	case OpSet:
+		data[s.Column] = s.value
+	case OpCopy:
+		if v, ok := data[s.From]; ok {
+			data[s.Column] = v
+		}
+	case OpConcat:
+		parts := make([]string, 0, len(s.Columns))
+		for _, k := range s.Columns {
+			if v, ok := data[k]; ok && string(v) != "null" {
+				parts = append(parts, text(v))
+			}
+		}
+		data[s.Column] = quote(strings.Join(parts, s.Separator))
+	case OpExpression:
+		data[s.Column] = eval(s.expr, data).json()
+	}
+}
+
+// Message 处理 maxwell 消息中的 data 和 old, 没有步骤时原样返回.
+// old 由 data 和 old 还原出修改前的行, 处理后和新的 data 比较得到, 改名和派生的列也能正确出现在 old 中
+func Message(steps []*Step, value []byte) []byte {
+	if len(steps) == 0 {
+		return value
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(value, &m); err != nil {
+		return value
+	}
+
+	var data, old map[string]json.RawMessage
+	if err := json.Unmarshal(m["data"], &data); err != nil || data == nil {
+		return value
+	}
+
+	if len(m["old"]) > 0 && m["old"][0] == '{' {
+		_ = json.Unmarshal(m["old"], &old)
+		prev := make(map[string]json.RawMessage, len(data))
+		for k, v := range data {
+			prev[k] = v
+		}
+		for k, v := range old {
+			prev[k] = v
+		}
+
+		Apply(steps, data)
+		Apply(steps, prev)
+		old = make(map[string]json.RawMessage)
+		for k, v := range prev {
+			if !bytes.Equal(v, data[k]) {
+				old[k] = v
+			}
+		}
+		m["old"], _ = json.Marshal(old)
+	} else {
+		Apply(steps, data)
+	}
+
+	m["data"], _ = json.Marshal(data)
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return value
+	}
+	return bs
+}
+
+// cast 转换失败时保持原值
+func cast(v json.RawMessage, typ string) json.RawMessage {
+	if string(v) == "null" {
+		return v
+	}
+
+	switch typ {
+	case "string":
+		return quote(text(v))
+	case "bool":
+		s := text(v)
+		if b, err := strconv.ParseBool(s); err == nil {
+			return json.RawMessage(strconv.FormatBool(b))
+		}
+		if r, ok := parseRat(s); ok {
+			return json.RawMessage(strconv.FormatBool(r.Sign() != 0))
+		}
+		switch strings.ToLower(s) {
+		case "yes", "y", "on":
+			return json.RawMessage("true")
+		case "no", "n", "off":
+			return json.RawMessage("false")
+		}
+	case "int", "float":
+		r, ok := parseRat(text(v))
+		if !ok {
+			return v
+		}
+		if typ == "int" {
+			return json.RawMessage(new(big.Int).Quo(r.Num(), r.Denom()).String())
+		}
+		f, _ := r.Float64()
+		return json.RawMessage(strconv.FormatFloat(f, 'f', -1, 64))
+	}
+	return v
+}
+
+// text 返回字符串的内容, 其他值的 json 文本
+func text(v json.RawMessage) string {
+	if len(v) > 0 && v[0] == '"' {
+		var s string
+		_ = json.Unmarshal(v, &s)
+		return s
+	}
+	return string(v)
+}
+
+func quote(s string) json.RawMessage {
+	bs, _ := json.Marshal(s)
+	return bs
+}
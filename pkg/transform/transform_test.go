@@ -0,0 +1,90 @@
+package transform
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	steps := []*Step{
+		{Op: OpDrop, Column: "password"},
+		{Op: OpRename, From: "parend_id", Column: "parent_id"},
+		{Op: OpExpression, Column: "balance_total", Expr: "balance_usdt + balance_hsn"},
+		{Op: OpCast, Column: "version", Type: "string"},
+		{Op: OpConcat, Column: "label", Columns: []string{"nick_name", "mobile", "id"}, Separator: "/"},
+		{Op: OpSet, Column: "source", Value: "mw"},
+		{Op: OpCopy, From: "id", Column: "uid"},
+	}
+	if err := Validate(steps); err != nil {
+		t.Fatal(err)
+	}
+
+	value := []byte(`{"type":"update","data":{"id":7,"parend_id":3,"password":"x","balance_usdt":"1.50","balance_hsn":"2.25","version":2,` +
+		`"nick_name":"n","mobile":null},"old":{"parend_id":1,"balance_hsn":"0.25","password":"y"}}`)
+
+	var m struct {
+		Data map[string]json.RawMessage `json:"data"`
+		Old  map[string]json.RawMessage `json:"old"`
+	}
+	if err := json.Unmarshal(Message(steps, value), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[string]string{
+		"parent_id":     `3`,
+		"balance_total": `"3.75"`,
+		"version":       `"2"`,
+		"label":         `"n/7"`,
+		"source":        `"mw"`,
+		"uid":           `7`,
+	} {
+		if string(m.Data[k]) != want {
+			t.Errorf("data %s: got %s, want %s", k, m.Data[k], want)
+		}
+	}
+
+	if _, ok := m.Data["password"]; ok {
+		t.Error("expect password to be dropped")
+	}
+
+	if len(m.Old) != 3 || string(m.Old["parent_id"]) != `1` || string(m.Old["balance_hsn"]) != `"0.25"` || string(m.Old["balance_total"]) != `"1.75"` {
+		t.Errorf("bad old: %v", m.Old)
+	}
+}
+
+func TestEval(t *testing.T) {
+	for _, c := range []struct {
+		expr, data, want string
+	}{
+		{"a * 2 + 1", `{"a":3}`, `7`},
+		{"(a - b) / 4", `{"a":3,"b":1}`, `0.50000000`},
+		{"a / b", `{"a":3,"b":0}`, `null`},
+		{"a + b", `{"a":3}`, `null`},
+		{`a + "-" + b`, `{"a":"x","b":1}`, `"x-1"`},
+		{"ext.x * 2", `{"ext.x":1.5}`, `3.0`},
+		{"-a", `{"a":0E-8}`, `0`},
+		{`a + "-" + b`, `{"a":"2019/09","b":"0x1F"}`, `"2019/09-0x1F"`}, // 分数和十六进制不是数字
+	} {
+		s := &Step{Op: OpExpression, Column: "r", Expr: c.expr}
+		if err := s.validate(); err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+
+		var data map[string]json.RawMessage
+		_ = json.Unmarshal([]byte(c.data), &data)
+		s.apply(data)
+		if string(data["r"]) != c.want {
+			t.Errorf("%s: got %s, want %s", c.expr, data["r"], c.want)
+		}
+	}
+
+	if err := (&Step{Op: OpExpression, Column: "r", Expr: "len(a)"}).validate(); err == nil {
+		t.Error("expect function call to be rejected")
+	}
+
+	for _, v := range []string{`"1/3"`, `"1_000"`} {
+		if got := cast(json.RawMessage(v), "int"); string(got) != v {
+			t.Errorf("cast %s: got %s", v, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package txbatch
+
+import (
+	"encoding/json"
+)
+
+// Batcher 决定缓存的 maxwell 消息什么时候写入.
+// TxOnly 时只在事务边界 (commit: true 或者不属于事务的 ddl) 之后切分, 写入的数据和 offset 不会停在事务中间
+type Batcher struct {
+	Size    int  // 缓存达到 Size 条后写入
+	TxOnly  bool // 只在事务边界切分
+	MaxSize int  // TxOnly 时的上限, 事务超过后拆开写入, 默认 Size*10
+
+	n      int // 缓存的条数
+	commit int // 前 commit 条是完整的事务
+}
+
+func (b *Batcher) maxSize() int {
+	if b.MaxSize > 0 {
+		return b.MaxSize
+	}
+	return b.Size * 10
+}
+
+// Add 记录一条缓存的消息, 返回需要写入的条数, 0 表示继续缓存
+func (b *Batcher) Add(value []byte) int {
+	b.n++
+	if !b.TxOnly {
+		if b.n >= b.Size {
+			return b.n
+		}
+		return 0
+	}
+
+	if Boundary(value) {
+		b.commit = b.n
+		if b.n >= b.Size {
+			return b.n
+		}
+	}
+
+	if b.n >= b.maxSize() {
+		return b.n
+	}
+	return 0
+}
+
+// Flush 定时写入时调用, 返回可以写入的条数, TxOnly 时不包括还没有结束的事务
+func (b *Batcher) Flush() int {
+	if !b.TxOnly || b.n >= b.maxSize() {
+		return b.n
+	}
+	return b.commit
+}
+
+// Partial 判断写入前 n 条是否会拆开一个事务
+func (b *Batcher) Partial(n int) bool {
+	return b.TxOnly && n > b.commit
+}
+
+// Done 在前 n 条写入之后调用
+func (b *Batcher) Done(n int) {
+	b.n -= n
+	if b.commit -= n; b.commit < 0 {
+		b.commit = 0
+	}
+}
+
+// Reset 在丢弃缓存时调用
+func (b *Batcher) Reset() {
+	b.n, b.commit = 0, 0
+}
+
+type event struct {
+	Xid    *int64 `json:"xid"`
+	Commit bool   `json:"commit"`
+}
+
+// Boundary 判断消息之后是否是事务边界
+func Boundary(value []byte) bool {
+	var e event
+	if err := json.Unmarshal(value, &e); err != nil {
+		return true
+	}
+	return e.Commit || e.Xid == nil
+}
+
+// Commit 返回事务最后一条消息 (commit: true) 的 xid
+func Commit(value []byte) (int64, bool) {
+	var e event
+	if err := json.Unmarshal(value, &e); err != nil || !e.Commit || e.Xid == nil {
+		return 0, false
+	}
+	return *e.Xid, true
+}
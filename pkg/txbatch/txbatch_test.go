@@ -0,0 +1,49 @@
+package txbatch
+
+import (
+	"testing"
+)
+
+func TestBatcher(t *testing.T) {
+	row := []byte(`{"type":"insert","xid":7}`)
+	commit := []byte(`{"type":"insert","xid":7,"commit":true}`)
+	ddl := []byte(`{"type":"table-alter"}`)
+
+	b := &Batcher{Size: 2, TxOnly: true, MaxSize: 5}
+	for i := 0; i < 3; i++ {
+		if n := b.Add(row); n != 0 {
+			t.Fatalf("expect 0 in transaction, got %d", n)
+		}
+	}
+
+	if n := b.Flush(); n != 0 {
+		t.Fatalf("expect nothing to flush, got %d", n)
+	}
+
+	if n := b.Add(commit); n != 4 {
+		t.Fatalf("expect 4 at commit, got %d", n)
+	}
+	b.Done(4)
+
+	b.Add(ddl)
+	b.Add(row)
+	if n := b.Flush(); n != 1 || b.Partial(n) {
+		t.Fatalf("expect to flush the ddl only, got %d", n)
+	}
+	b.Done(1)
+
+	for i := 0; i < 3; i++ {
+		b.Add(row)
+	}
+	if n := b.Add(row); n != 5 || !b.Partial(n) {
+		t.Fatalf("expect a split at MaxSize, got %d", n)
+	}
+
+	if xid, ok := Commit(commit); !ok || xid != 7 {
+		t.Errorf("expect xid 7, got %d %v", xid, ok)
+	}
+
+	if _, ok := Commit(row); ok {
+		t.Error("expect no commit")
+	}
+}